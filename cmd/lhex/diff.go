@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/dnesting/lhex"
+)
+
+func init() {
+	commands["diff"] = command{runDiff, "describe how two files differ"}
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	raw := fs.Bool("raw", false, "treat both inputs as raw binary rather than hex dumps")
+	rawOld := fs.Bool("raw-old", false, "treat the old input as raw binary")
+	rawNew := fs.Bool("raw-new", false, "treat the new input as raw binary")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex diff [flags] old new\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected two files")
+	}
+
+	a, err := readFile(fs.Arg(0), *raw || *rawOld)
+	if err != nil {
+		return err
+	}
+	b, err := readFile(fs.Arg(1), *raw || *rawNew)
+	if err != nil {
+		return err
+	}
+	return lhex.Diff(os.Stdout, a, b)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+func init() {
+	commands["elf"] = command{runELF, "dump an ELF file with its sections and symbols labelled"}
+}
+
+func runELF(args []string) error {
+	fs := flag.NewFlagSet("elf", flag.ExitOnError)
+	sections := fs.String("sections", "", "comma-separated names of the sections to dump (default all)")
+	segments := fs.Bool("segments", false, "dump loadable segments instead of sections")
+	vaddr := fs.Bool("vaddr", false, "place data at virtual addresses rather than file offsets")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex elf [flags] file\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one file")
+	}
+
+	f, err := elf.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts := objfile.ELFOptions{Segments: *segments, VirtualAddresses: *vaddr}
+	if *sections != "" {
+		opts.Sections = strings.Split(*sections, ",")
+	}
+	out, err := objfile.ELF(f, opts)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
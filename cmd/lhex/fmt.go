@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/dnesting/lhex"
+)
+
+func init() {
+	commands["fmt"] = command{runFmt, "rewrite a hex dump in canonical form"}
+}
+
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result back to the file instead of to stdout")
+	dialectName := fs.String("dialect", "", "read dumps embedded in other output: kernel (print_hex_dump) or gdb (x/xb)")
+	prefix := fs.String("prefix", "", "strip text matching this regexp from the start of each line, skipping lines it doesn't match")
+	fill := fs.Int("fill", -1, "write runs of at least `n` identical bytes as .fill directives, or 0 for none (default the shortest .fill in the input)")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex fmt [flags] [file.lhex]\n\n" +
+			"Lines containing string or integer literals are rewritten as plain hex, with the\n" +
+			"original line kept as a comment.  With -dialect or -prefix, hex dumps are pulled\n" +
+			"out of other output, like log files.  Transcripts aren't supported.\n\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	path := "-"
+	switch {
+	case fs.NArg() == 1:
+		path = fs.Arg(0)
+	case fs.NArg() > 1:
+		fs.Usage()
+		return errors.New("expected at most one file")
+	}
+	if *write && path == "-" {
+		return errors.New("-w requires a file")
+	}
+	var dialect *lhex.Dialect
+	switch *dialectName {
+	case "":
+	case "kernel":
+		dialect = lhex.KernelHexDump
+	case "gdb":
+		dialect = lhex.GDB
+	default:
+		return fmt.Errorf("unknown dialect %q", *dialectName)
+	}
+	if *prefix != "" {
+		re, err := regexp.Compile(*prefix)
+		if err != nil {
+			return err
+		}
+		var d lhex.Dialect
+		if dialect != nil {
+			d = *dialect
+		}
+		d.Prefix = re
+		dialect = &d
+	}
+
+	var in []byte
+	var err error
+	if path == "-" {
+		in, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		in, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := format(in, dialect, *fill)
+	if err != nil {
+		return err
+	}
+	if !*write {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return replaceFile(path, out)
+}
+
+var (
+	// transcriptRE matches the direction markers that start each block of a transcript.
+	transcriptRE = regexp.MustCompile(`(?m)^[ \t]*[<>][ \t]*(#.*)?$`)
+
+	// fillRE matches .fill directives, capturing their lengths.
+	fillRE = regexp.MustCompile(`(?m)^[^#\n]*\.fill[ \t]+(0[xX][0-9A-Fa-f]+|[0-9]+)`)
+)
+
+// format returns the hex dump in in rewritten in canonical form, pulling it out of other
+// output using dialect if it isn't nil.  Runs of at least fill identical bytes are written as
+// .fill directives, or if fill is negative, runs at least as long as the shortest .fill in in.
+func format(in []byte, dialect *lhex.Dialect, fill int) ([]byte, error) {
+	if transcriptRE.Match(in) {
+		return nil, errors.New("input is a transcript, which fmt can't rewrite")
+	}
+	if fill < 0 {
+		fill = 0
+		for _, m := range fillRE.FindAllSubmatch(in, -1) {
+			s, base := string(m[1]), 10
+			if len(s) > 2 && (s[1] == 'x' || s[1] == 'X') {
+				s, base = s[2:], 16
+			}
+			if n, err := strconv.ParseInt(s, base, 0); err == nil && n > 0 && (fill == 0 || int(n) < fill) {
+				fill = int(n)
+			}
+		}
+	}
+
+	dec := lhex.NewDecoder(bytes.NewReader(in))
+	dec.SetKeepLiterals(true)
+	dec.SetDialect(dialect)
+	segs, err := lhex.ReadSegments(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	dmp := lhex.NewDumper(&buf, dec.Labels())
+	dmp.SetComments(dec.Comments())
+	dmp.SetFill(fill)
+	for _, s := range segs {
+		if _, err := dmp.Seek(s.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := dmp.WriteMasked(s.Data, s.Mask); err != nil {
+			return nil, err
+		}
+	}
+	if err := dmp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// replaceFile replaces the contents of the file at path with data, keeping its permissions.
+// The data is written to a temporary file that then takes the original's place, so the
+// original is left alone if anything goes wrong.
+func replaceFile(path string, data []byte) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), fi.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
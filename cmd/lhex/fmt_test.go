@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatFill(t *testing.T) {
+	in := `00000000  "hi"
+00000010  .fill 0x40 FF
+00000050  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00
+`
+	out, err := format([]byte(in), nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# 00000000  "hi"
+00000000  68 69                                             |hi|
+
+00000010  .fill 0x40 FF
+00000050  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+`
+	if string(out) != expected {
+		t.Errorf("fmt should keep the fill, expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	if out, err = format([]byte(in), nil, 0); err != nil || strings.Contains(string(out), ".fill") {
+		t.Errorf("fmt with no fills should expand them, got %v:\n%s", err, out)
+	}
+}
+
+func TestFormatTranscript(t *testing.T) {
+	in := `> # request
+00000000  68 69
+<
+00000000  6F 6B
+`
+	if _, err := format([]byte(in), nil, -1); err == nil || !strings.Contains(err.Error(), "transcript") {
+		t.Errorf("fmt should refuse a transcript, got %v", err)
+	}
+}
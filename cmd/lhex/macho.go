@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+func init() {
+	commands["macho"] = command{runMachO, "dump a Mach-O file with its headers, sections and symbols labelled"}
+}
+
+func runMachO(args []string) error {
+	fs := flag.NewFlagSet("macho", flag.ExitOnError)
+	sections := fs.String("sections", "", "comma-separated names of the sections to dump, like __text or __TEXT.__text (default all)")
+	segments := fs.Bool("segments", false, "dump segments instead of sections")
+	vaddr := fs.Bool("vaddr", false, "place data at virtual addresses rather than file offsets")
+	headers := fs.Bool("headers", true, "dump the header and load commands, describing each field")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex macho [flags] file\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts := objfile.MachOOptions{Segments: *segments, VirtualAddresses: *vaddr, Headers: *headers}
+	if *sections != "" {
+		opts.Sections = strings.Split(*sections, ",")
+	}
+	out, err := objfile.MachO(f, opts)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
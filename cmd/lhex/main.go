@@ -0,0 +1,87 @@
+// Command lhex works with annotated hexdump files in the lhex format.
+//
+// Usage:
+//
+//	lhex <command> [flags] [args...]
+//
+// The commands are:
+//
+//	diff     describe how two files differ
+//	elf      dump an ELF file with its sections and symbols labelled
+//	fmt      rewrite a hex dump in canonical form
+//	macho    dump a Mach-O file with its headers, sections and symbols labelled
+//	match    check a file against a hex dump template
+//	mkelf    write the data in a hex dump as an ELF file with its labels as symbols
+//	mkpcap   write the segments in a hex dump as packets in a pcapng or pcap capture
+//	patch    write the bytes described by a hex dump into a file
+//	pcap     dump the packets in a pcap or pcapng capture
+//	pe       dump a PE or COFF file with its headers, sections and symbols labelled
+//	redact   replace sensitive bytes in a hex dump with wildcards
+//
+// Run "lhex <command> -h" for details on a command's flags.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/dnesting/lhex"
+)
+
+// command is a subcommand, taking its arguments without the command name.
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lhex <command> [flags] [args...]\n\ncommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "lhex %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// readFile reads the file at path, or stdin if path is "-".  If raw is true, the contents are
+// taken as binary data starting at offset 0; otherwise they are decoded as a hex dump.
+func readFile(path string, raw bool) (*lhex.File, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	if !raw {
+		return lhex.Decode(f)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return lhex.NewFile(data, 0), nil
+}
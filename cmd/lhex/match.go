@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dnesting/lhex"
+)
+
+func init() {
+	commands["match"] = command{runMatch, "check a file against a hex dump template"}
+}
+
+func runMatch(args []string) error {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	find := fs.Bool("find", false, "list every offset in the file at which the template matches")
+	limit := fs.Int("n", 10, "report at most this many mismatches, or all of them if 0")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex match [flags] template.lhex file\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a template and a file")
+	}
+
+	template, err := readFile(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *find {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		found, err := lhex.FindAll(f, fi.Size(), template)
+		if err != nil {
+			return err
+		}
+		for _, ofs := range found {
+			fmt.Printf("%08X\n", ofs)
+		}
+		if len(found) == 0 {
+			return errors.New("no match")
+		}
+		return nil
+	}
+
+	mm, err := lhex.Match(f, template, *limit)
+	if err != nil {
+		return err
+	}
+	for _, m := range mm {
+		fmt.Println(m)
+	}
+	if len(mm) > 0 {
+		return errors.New("file does not match")
+	}
+	return nil
+}
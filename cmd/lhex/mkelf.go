@@ -0,0 +1,86 @@
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+func init() {
+	commands["mkelf"] = command{runMkELF, "write the data in a hex dump as an ELF file with its labels as symbols"}
+}
+
+func runMkELF(args []string) error {
+	fs := flag.NewFlagSet("mkelf", flag.ExitOnError)
+	bits := fs.Int("bits", 64, "ELF class, 32 or 64")
+	bigEndian := fs.Bool("be", false, "write a big-endian file")
+	machine := fs.String("machine", "", "target machine, like x86_64 or aarch64 (default none)")
+	entry := fs.String("entry", "", "entry point, as a label, label+offset or number")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex mkelf [flags] dump.lhex out.elf\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a hex dump and an output file")
+	}
+
+	var opts objfile.WriteELFOptions
+	switch *bits {
+	case 32:
+		opts.Class = elf.ELFCLASS32
+	case 64:
+		opts.Class = elf.ELFCLASS64
+	default:
+		return fmt.Errorf("invalid -bits %d", *bits)
+	}
+	if *bigEndian {
+		opts.Data = elf.ELFDATA2MSB
+	}
+	if *machine != "" {
+		m, err := parseMachine(*machine)
+		if err != nil {
+			return err
+		}
+		opts.Machine = m
+	}
+
+	in, err := readFile(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	if *entry != "" {
+		ofs, err := in.Labels.Resolve(*entry)
+		if err != nil {
+			return fmt.Errorf("-entry: %v", err)
+		}
+		opts.Entry = uint64(ofs)
+	}
+
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	err = objfile.WriteELF(out, in, opts)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// parseMachine returns the elf.Machine named name, like "x86_64" for EM_X86_64.
+func parseMachine(name string) (elf.Machine, error) {
+	want := "EM_" + strings.ToUpper(strings.TrimPrefix(name, "EM_"))
+	for m := elf.Machine(0); m < 1<<10; m++ {
+		if m.String() == want {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown machine %q", name)
+}
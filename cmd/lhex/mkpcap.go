@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dnesting/lhex/pcap"
+)
+
+func init() {
+	commands["mkpcap"] = command{runMkPcap, "write the segments in a hex dump as packets in a pcapng or pcap capture"}
+}
+
+func runMkPcap(args []string) error {
+	fs := flag.NewFlagSet("mkpcap", flag.ExitOnError)
+	linkType := fs.Int64("linktype", -1, "link type number, like 1 for Ethernet or 0 for NULL (default from the dump's comments, or Ethernet)")
+	classic := fs.Bool("classic", false, "write a pcap capture instead of pcapng, without comments")
+	start := fs.String("start", "", "RFC 3339 time of packets without their own timestamp (default the Unix epoch)")
+	interval := fs.Duration("interval", time.Millisecond, "time between packets without their own timestamp")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex mkpcap [flags] dump.lhex out.pcapng\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a hex dump and an output file")
+	}
+
+	opts := pcap.WriteOptions{Classic: *classic, Interval: *interval}
+	if *linkType >= 0 {
+		if *linkType > 0xFFFF {
+			return fmt.Errorf("invalid -linktype: %d is more than 65535", *linkType)
+		}
+		opts.LinkType, opts.HasLinkType = pcap.LinkType(*linkType), true
+	}
+	if *start != "" {
+		var err error
+		if opts.Start, err = time.Parse(time.RFC3339Nano, *start); err != nil {
+			return fmt.Errorf("invalid -start: %v", err)
+		}
+	}
+	in, err := readFile(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	err = pcap.Encode(out, in, opts)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/dnesting/lhex"
+)
+
+func init() {
+	commands["patch"] = command{runPatch, "write the bytes described by a hex dump into a file"}
+}
+
+func runPatch(args []string) error {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	expect := fs.String("expect", "", "refuse to patch unless the target contains the bytes in this hex dump")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex patch [flags] patch.lhex target\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a patch and a target file")
+	}
+
+	patch, err := readFile(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	target, err := os.OpenFile(fs.Arg(1), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if *expect != "" {
+		var orig *lhex.File
+		if orig, err = readFile(*expect, false); err == nil {
+			err = lhex.ApplyExpect(target, patch, orig)
+		}
+	} else {
+		err = lhex.Apply(target, patch)
+	}
+	if cerr := target.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
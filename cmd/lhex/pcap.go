@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/dnesting/lhex/pcap"
+)
+
+func init() {
+	commands["pcap"] = command{runPcap, "dump the packets in a pcap or pcapng capture"}
+}
+
+func runPcap(args []string) error {
+	fs := flag.NewFlagSet("pcap", flag.ExitOnError)
+	align := fs.Int64("align", 0, "pack packets together, each starting at a multiple of this, instead of using capture offsets")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex pcap [flags] capture\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one capture file")
+	}
+
+	f := os.Stdin
+	if fs.Arg(0) != "-" {
+		var err error
+		if f, err = os.Open(fs.Arg(0)); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	out, err := pcap.Decode(f, pcap.Options{Align: *align})
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+func init() {
+	commands["pe"] = command{runPE, "dump a PE or COFF file with its headers, sections and symbols labelled"}
+}
+
+func runPE(args []string) error {
+	fs := flag.NewFlagSet("pe", flag.ExitOnError)
+	sections := fs.String("sections", "", "comma-separated names of the sections to dump (default all)")
+	vaddr := fs.Bool("vaddr", false, "place data at virtual addresses rather than file offsets")
+	headers := fs.Bool("headers", true, "dump the headers and section table, describing each field")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex pe [flags] file\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts := objfile.PEOptions{VirtualAddresses: *vaddr, Headers: *headers}
+	if *sections != "" {
+		opts.Sections = strings.Split(*sections, ",")
+	}
+	out, err := objfile.PE(f, opts)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
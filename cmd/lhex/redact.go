@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dnesting/lhex"
+)
+
+func init() {
+	commands["redact"] = command{runRedact, "replace sensitive bytes in a hex dump with wildcards"}
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+func runRedact(args []string) error {
+	fs := flag.NewFlagSet("redact", flag.ExitOnError)
+	var labels, regions, patterns stringList
+	fs.Var(&labels, "label", "redact from this label up to the next one (may be repeated)")
+	fs.Var(&regions, "region", "redact the region `start..end`, given as offsets or label expressions (may be repeated)")
+	fs.Var(&patterns, "pattern", "redact bytes matching this regular expression (may be repeated)")
+	key := fs.String("key", "", "note the HMAC-SHA-256 of each redacted region using this `key`, so that dumps redacted with the same key can be compared")
+	raw := fs.Bool("raw", false, "treat the input as raw binary rather than a hex dump")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: lhex redact [flags] [file]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	path := "-"
+	switch {
+	case fs.NArg() == 1:
+		path = fs.Arg(0)
+	case fs.NArg() > 1:
+		fs.Usage()
+		return errors.New("expected at most one file")
+	}
+
+	f, err := readFile(path, *raw)
+	if err != nil {
+		return err
+	}
+	dmp := lhex.NewDumper(os.Stdout, f.Labels)
+	dmp.SetComments(f.Comments)
+	if *key != "" {
+		dmp.SetRedactionKey([]byte(*key))
+	}
+
+	for _, name := range labels {
+		start, ok := f.Labels.Get(name)
+		if !ok {
+			return fmt.Errorf("undefined label %q", name)
+		}
+		dmp.Redact(start, labelEnd(f, start))
+	}
+	for _, spec := range regions {
+		i := strings.Index(spec, "..")
+		if i < 0 {
+			return fmt.Errorf("region %q should look like start..end", spec)
+		}
+		start, err := f.Labels.Resolve(spec[:i])
+		if err != nil {
+			return err
+		}
+		end, err := f.Labels.Resolve(spec[i+2:])
+		if err != nil {
+			return err
+		}
+		dmp.Redact(start, end)
+	}
+	for _, pat := range patterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return err
+		}
+		for _, s := range f.Segments {
+			for _, m := range re.FindAllIndex(s.Data, -1) {
+				dmp.Redact(s.Offset+int64(m[0]), s.Offset+int64(m[1]))
+			}
+		}
+	}
+
+	for _, s := range f.Segments {
+		if _, err := dmp.Seek(s.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dmp.WriteMasked(s.Data, s.Mask); err != nil {
+			return err
+		}
+	}
+	return dmp.Close()
+}
+
+// labelEnd returns the offset of the first label following start, or the end of the segment
+// containing start if that comes first.
+func labelEnd(f *lhex.File, start int64) int64 {
+	end := int64(-1)
+	for _, s := range f.Segments {
+		if start >= s.Offset && start < s.End() {
+			end = s.End()
+		}
+	}
+	if at, _, ok := f.Labels.FirstAt(start + 1); ok && (end < 0 || at < end) {
+		end = at
+	}
+	return end
+}
@@ -0,0 +1,113 @@
+package lhex
+
+import (
+	"sort"
+	"sync"
+)
+
+// Comments associates lines of commentary with offsets.  A Dumper given a
+// Comments instance will emit the comments ahead of any labels and data at the
+// same offset.  Like Labels, Comments is safe for concurrent use.
+type Comments struct {
+	mu sync.RWMutex
+
+	// The slices in offComments are replaced rather than modified, so callers may hold on to
+	// them without holding mu.
+	offComments map[int64][]string
+	offsets     []int64
+}
+
+// Add appends a comment line to those already present at ofs.
+func (c *Comments) Add(ofs int64, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offComments == nil {
+		c.offComments = make(map[int64][]string)
+	}
+	texts, ok := c.offComments[ofs]
+	if !ok {
+		i := sort.Search(len(c.offsets), func(i int) bool { return c.offsets[i] >= ofs })
+		c.offsets = append(c.offsets, 0)
+		copy(c.offsets[i+1:], c.offsets[i:])
+		c.offsets[i] = ofs
+	}
+	updated := make([]string, 0, len(texts)+1)
+	c.offComments[ofs] = append(append(updated, texts...), text)
+}
+
+// Get returns the comment lines at ofs, in the order they were added.
+func (c *Comments) Get(ofs int64) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offComments[ofs]
+}
+
+// Snapshot returns a copy of c that will not reflect future changes to c.
+func (c *Comments) Snapshot() *Comments {
+	snap := &Comments{offComments: make(map[int64][]string)}
+	if c != nil {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		for ofs, texts := range c.offComments {
+			snap.offComments[ofs] = texts
+		}
+		snap.offsets = append(snap.offsets, c.offsets...)
+	}
+	return snap
+}
+
+// first returns the first offset at or after ofs having comments, along with those comments.
+func (c *Comments) first(ofs int64) (int64, []string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	i := sort.Search(len(c.offsets), func(i int) bool { return c.offsets[i] >= ofs })
+	if i == len(c.offsets) {
+		return -1, nil, false
+	}
+	return c.offsets[i], c.offComments[c.offsets[i]], true
+}
+
+func (c *Comments) upcoming(from, until int64, buf []offsetEntry) []offsetEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return appendUpcoming(c.offsets, c.offComments, from, until, buf)
+}
+
+// Iter returns an iterator on comments in order of offset, positioned at the first offset at or
+// after ofs having comments.  Like Labels.Iter, comments added while iterating are visited if
+// they lie beyond the iterator's current position.
+func (c *Comments) Iter(ofs int64) *CommentIter {
+	it := &CommentIter{it: c.iter(ofs)}
+	it.Ofs, it.Comments = it.it.Ofs, it.it.Labels
+	return it
+}
+
+// CommentIter is an iterator on comment offsets, created by Comments.Iter.
+type CommentIter struct {
+	// Ofs is the offset of the next comments.  If no more comments exist, this will be <0.
+	Ofs int64
+	// Comments contains the comment lines at Ofs, in the order they were added.  If no more
+	// comments exist, this will be nil.  Callers must not modify it.
+	Comments []string
+
+	it LabelIter
+}
+
+// Next advances to the next offset that has comments.  If no more comments exist, returns
+// false.
+func (it *CommentIter) Next() bool {
+	ok := it.it.Next()
+	it.Ofs, it.Comments = it.it.Ofs, it.it.Labels
+	return ok
+}
+
+// iter creates an iterator on comments, starting at or after ofs.
+func (c *Comments) iter(ofs int64) LabelIter {
+	if c == nil {
+		return newLabelIter(nil, ofs)
+	}
+	return newLabelIter(c, ofs)
+}
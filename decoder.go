@@ -1,31 +1,106 @@
 package lhex
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 )
 
+// unresolved holds a label, comment or reference whose offset can't be known until we see a
+// line with an offset.
 type unresolved struct {
-	label string
-	rel   int
+	label   string
+	comment string
+	isLabel bool
+	ref     *ref  // if non-nil, a reference at rel+ref.pos
+	rel     int64 // offset relative to the start of the pending data
 }
 
+// fixup is a reference whose location is known, waiting for the labels it refers to.
+type fixup struct {
+	ofs int64
+	ref ref
+}
+
+// chunk is a run of decoded data at ofs.  If fill is nonzero, the chunk is fill bytes long,
+// made up of data repeated as a pattern starting phase bytes into it.  Otherwise mask, if not
+// empty, gives the known bits of each byte in data.
+type chunk struct {
+	ofs   int64
+	data  []byte
+	mask  []byte
+	fill  int64
+	phase int
+}
+
+// size returns the number of bytes in the chunk.
+func (c *chunk) size() int64 {
+	if c.fill > 0 {
+		return c.fill
+	}
+	return int64(len(c.data))
+}
+
+// end returns the offset immediately following the chunk.
+func (c *chunk) end() int64 { return c.ofs + c.size() }
+
+// appendMasked appends data and its mask to buf and bufMask.  A mask is either empty, meaning
+// every byte is known, or the same length as its data.
+func appendMasked(buf, bufMask, data, mask []byte) ([]byte, []byte) {
+	if len(mask) > 0 || len(bufMask) > 0 {
+		for len(bufMask) < len(buf) {
+			bufMask = append(bufMask, 0xFF)
+		}
+		if len(mask) > 0 {
+			bufMask = append(bufMask, mask...)
+		} else {
+			for range data {
+				bufMask = append(bufMask, 0xFF)
+			}
+		}
+	}
+	return append(buf, data...), bufMask
+}
+
+// fillChunk is the most data the Decoder will generate at once from a fill directive.
+const fillChunk = 4096
+
 // Decoder takes an input io.Reader providing input in hexdump form, and
 // implements sparse.Reader to make the bytes described by the input available
 // to the caller.  Callers may call Read() to read the bytes, and Next() to
 // advance between segments of data if the input contains gaps.
+//
+// Data lines may contain typed references to labels, which the Decoder fills
+// in once the labels are known.  If a reference can't be resolved right away,
+// the Decoder reads ahead, holding the data back from callers until the
+// labels it needs have been defined.  Regions described by a fill directive
+// are generated as they are read, so they may be arbitrarily large.
+//
+// Bytes given as wildcards like "??" read as zero.  Use ReadMasked to learn
+// which bytes are wildcards.
 type Decoder struct {
-	err    error
-	labels Labels
-	scan   *scanner
+	err      error
+	labels   Labels
+	comments Comments
+	scan     *scanner
+
+	// Decoded data flows from pending (offset not yet known), to queue (chunks of data with
+	// known offsets), and from there into buf as the caller reads it.  Data contiguous with
+	// buf is appended to it directly when nothing else is queued.  Each buffer is reused once
+	// drained, so steady-state decoding doesn't allocate.
+	readyOfs int64    // offset of buf[pos]
+	buf      []byte   // data ready to be read from the current segment
+	bufMask  []byte   // the mask for buf, or empty if it has no wildcards
+	pos      int      // read cursor into buf
+	pending  []chunk  // data whose offset isn't known yet, with offsets relative to its start
+	pendLen  int64    // the total size of pending
+	queue    []chunk  // data following buf, in order
+	spare    [][]byte // drained buffers, available for reuse
+	resolv   []unresolved
+	fixups   []fixup // references waiting on undefined labels
+	shift    int64   // how far the current dump embedded in other output has been moved
 
-	started    bool
-	readyOfs   int64 // start of data[]
-	data       []byte
-	nextData   []byte
-	nextOffset int64
-	resolv     []unresolved
+	keepLiterals bool
 }
 
 // NewDecoder creates a Decoder from the given reader.
@@ -35,126 +110,359 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// SetKeepLiterals causes the Decoder to add the text of each data line containing string or
+// typed integer literals to its Comments, at the offset of the line's first byte.  A Dumper
+// writing the decoded data will then produce plain hex, annotated with the lines it came from.
+func (d *Decoder) SetKeepLiterals(keep bool) {
+	d.keepLiterals = keep
+}
+
 // Next moves to the next block of data, in the event the data described
-// by the input hexdump isn't contiguous.  Returns io.EOF when the end of
-// the hexdump input is reached.  Note that skips may be negative if the
-// offsets described by the hexdump are out of order.
+// by the input hexdump isn't contiguous.  Any unread data in the current
+// block is discarded.  Returns io.EOF when the end of the hexdump input is
+// reached.
 func (d *Decoder) Next() (skipped int64, err error) {
-	if !d.started {
-		if _, err = d.nextContiguous(); err != nil {
-			return 0, err
+	for {
+		d.discard()
+		for len(d.queue) > 0 && d.queue[0].ofs == d.readyOfs {
+			d.readyOfs = d.queue[0].end()
+			d.pop()
 		}
-	}
-	if d.err != nil {
-		return 0, d.err
-	}
-	if d.nextData == nil && d.nextOffset == 0 {
-		_, err = io.Copy(ioutil.Discard, d)
-		if err != nil {
-			return
+		if len(d.queue) > 0 {
+			break
+		}
+		if d.err != nil {
+			return 0, d.err
 		}
+		d.err = d.fill()
 	}
-	d.data = d.nextData
-	lastOfs := d.readyOfs
-	d.readyOfs = d.nextOffset
-	d.nextData = nil
-	d.nextOffset = 0
-
-	skipped = d.readyOfs - lastOfs
+	skipped = d.queue[0].ofs - d.readyOfs
+	d.readyOfs = d.queue[0].ofs
 	return
 }
 
+// discard drops any unread data from buf.
+func (d *Decoder) discard() {
+	d.readyOfs += int64(len(d.buf) - d.pos)
+	d.buf, d.bufMask, d.pos = d.buf[:0], d.bufMask[:0], 0
+}
+
 // Read reads up to len(p) of raw bytes from the hexdump input.  In the event
 // the hexdump input skips to a non-contiguous offset, Read will only read
 // from the current segment and will then return io.EOF.  Callers should call
 // Next() to move to the new segment of data in the hexdump, at which point
 // Read will read from that segment.
 func (d *Decoder) Read(p []byte) (n int, err error) {
-	if d.nextOffset > 0 {
-		// we've exhausted one stream and are waiting for the caller to call Next to move on to
-		// the next one.
-		return 0, io.EOF
+	return d.read(p, nil)
+}
+
+// ReadMasked is like Read, but also fills mask with the mask for each byte read into p, whose
+// bits are set where the byte's bits are known.  Bytes given in the input in hex have a mask
+// of 0xFF, and wildcards like "??" have a mask of 0x00, or 0xF0 for a wildcard like "4?".  The
+// wildcard bits themselves read as 0.  mask must be at least as long as p.
+func (d *Decoder) ReadMasked(p, mask []byte) (n int, err error) {
+	if len(mask) < len(p) {
+		return 0, errors.New("mask shorter than p")
 	}
-	for n < len(p) && err == nil {
+	return d.read(p, mask)
+}
+
+// read implements Read and ReadMasked.  If mask is nil, no mask is needed.
+func (d *Decoder) read(p, mask []byte) (n int, err error) {
+	for n < len(p) {
 		// first try to satisfy from buffer
-		if len(d.data) > 0 {
-			nn := copy(p[n:], d.data)
+		if d.pos < len(d.buf) {
+			nn := copy(p[n:], d.buf[d.pos:])
+			if mask != nil {
+				if len(d.bufMask) > 0 {
+					copy(mask[n:n+nn], d.bufMask[d.pos:])
+				} else {
+					for i := n; i < n+nn; i++ {
+						mask[i] = 0xFF
+					}
+				}
+			}
 			n += nn
-			copy(d.data, d.data[nn:])
-			d.data = d.data[nn:]
+			d.pos += nn
 			d.readyOfs += int64(nn)
 			continue
 		}
-		// then try to read from the input
-		d.data, err = d.nextContiguous()
-		if err != nil {
-			d.err = err
+		if d.advance() {
+			continue
 		}
-		if d.data == nil && err == nil {
-			err = io.EOF
+		// then try to read from the input, unless we've exhausted this segment and are waiting
+		// for the caller to call Next to move on to the next one.
+		if len(d.queue) > 0 || d.err != nil {
+			break
 		}
+		d.discard()
+		d.err = d.fill()
 	}
-	if n == 0 && err == nil {
-		//spew.Dump(d)
-		panic("n==0 err==nil")
+	if n == 0 && len(p) > 0 {
+		if d.err != nil && len(d.queue) == 0 {
+			return 0, d.err
+		}
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// advance moves data from the chunk at the front of the queue into the drained buf, if that
+// chunk continues the current segment.
+func (d *Decoder) advance() bool {
+	if len(d.queue) == 0 || d.queue[0].ofs != d.readyOfs {
+		return false
+	}
+	c := &d.queue[0]
+	if c.fill == 0 {
+		d.spare = append(d.spare, d.buf[:0])
+		d.buf, d.bufMask, d.pos = c.data, c.mask, 0
+		c.data = nil
+		d.pop()
+		return true
+	}
+
+	n := fillChunk
+	if c.fill < int64(n) {
+		n = int(c.fill)
+	}
+	buf := d.buf[:0]
+	for len(buf) < n {
+		k := len(c.data) - c.phase
+		if k > n-len(buf) {
+			k = n - len(buf)
+		}
+		buf = append(buf, c.data[c.phase:c.phase+k]...)
+		c.phase = (c.phase + k) % len(c.data)
+	}
+	d.buf, d.bufMask, d.pos = buf, d.bufMask[:0], 0
+	c.ofs += int64(n)
+	if c.fill -= int64(n); c.fill == 0 {
+		d.pop()
+	}
+	return true
+}
+
+// pop removes the chunk at the front of the queue, keeping its buffer for reuse.
+func (d *Decoder) pop() {
+	if d.queue[0].data != nil {
+		d.spare = append(d.spare, d.queue[0].data[:0])
+	}
+	copy(d.queue, d.queue[1:])
+	d.queue[len(d.queue)-1] = chunk{}
+	d.queue = d.queue[:len(d.queue)-1]
+}
+
+// end returns the offset following the last data decoded so far.
+func (d *Decoder) end() int64 {
+	if n := len(d.queue); n > 0 {
+		return d.queue[n-1].end()
+	}
+	return d.readyOfs + int64(len(d.buf)-d.pos)
+}
+
+// pend adds data whose offset isn't known yet.  If fill is nonzero, data is a pattern to be
+// repeated for fill bytes.
+func (d *Decoder) pend(data, mask []byte, fill int64) {
+	n := len(d.pending)
+	if fill == 0 && n > 0 && d.pending[n-1].fill == 0 {
+		c := &d.pending[n-1]
+		c.data, c.mask = appendMasked(c.data, c.mask, data, mask)
+	} else {
+		if n < cap(d.pending) {
+			d.pending = d.pending[:n+1]
+		} else {
+			d.pending = append(d.pending, chunk{})
+		}
+		c := &d.pending[n]
+		c.ofs, c.fill, c.phase = d.pendLen, fill, 0
+		c.data, c.mask = appendMasked(c.data[:0], c.mask[:0], data, mask)
+	}
+	if fill > 0 {
+		d.pendLen += fill
+	} else {
+		d.pendLen += int64(len(data))
+	}
+}
+
+// storePending stores the pending data, now that we know it starts at ofs.
+func (d *Decoder) storePending(ofs int64) {
+	for _, c := range d.pending {
+		c.ofs += ofs
+		d.store(c)
+	}
+	d.pending, d.pendLen = d.pending[:0], 0
+}
+
+// store places a copy of c after the data decoded so far.
+func (d *Decoder) store(c chunk) {
+	n := len(d.queue)
+	switch {
+	case c.size() == 0:
+	case c.fill == 0 && n == 0 && c.ofs == d.end():
+		d.buf, d.bufMask = appendMasked(d.buf, d.bufMask, c.data, c.mask)
+	case c.fill == 0 && n > 0 && d.queue[n-1].fill == 0 && c.ofs == d.queue[n-1].end():
+		last := &d.queue[n-1]
+		last.data, last.mask = appendMasked(last.data, last.mask, c.data, c.mask)
+	default:
+		var buf []byte
+		if k := len(d.spare); k > 0 {
+			buf, d.spare = d.spare[k-1], d.spare[:k-1]
+		}
+		c.data, c.mask = appendMasked(buf, nil, c.data, c.mask)
+		d.queue = append(d.queue, c)
 	}
-	return
 }
 
-// nextContiguous reads until we get a block of data known to be contiguous with the
-// last block of data.  Returns nil, nil if we need to interrupt the flow to handle a
-// change in offset.
-func (d *Decoder) nextContiguous() (data []byte, err error) {
-	var pending []byte
-	var resolv []unresolved
-	d.started = true
-	for len(data) == 0 {
-		var lineOfs int64
-		var hasOfs bool
-		var label string
-		lineOfs, hasOfs, data, label, err = d.scan.decodeLine()
+// fill decodes input until more data has been stored after the current end of the decoded
+// data.  While any references remain unresolved, it keeps going until they are.
+func (d *Decoder) fill() error {
+	for {
+		ln, err := d.scan.decodeLine()
 		if err != nil {
-			// no final offset means we just assume any partial data is contiguous with the prior,
-			// so return that first.  A subsequent call will presumably get the same error
-			// from decodeLine.
-			if len(pending) > 0 {
-				return pending, nil
+			return d.finish(err)
+		}
+		if ln.dir != 0 {
+			return fmt.Errorf("direction marker %q outside a transcript", ln.dir.marker())
+		}
+		end := d.end()
+		if err := d.add(&ln); err != nil {
+			return err
+		}
+		if len(d.fixups) == 0 && d.end() > end {
+			return nil
+		}
+	}
+}
+
+// finish handles the end of the input, which decodeLine reported with err.  Any data still
+// pending is stored and err is held back until the caller has read it.
+func (d *Decoder) finish(err error) error {
+	// no final offset means we just assume any partial data is contiguous with the prior,
+	// so return that first.  A subsequent call will presumably get the same error
+	// from decodeLine.
+	end := d.end()
+	d.settle()
+	if ferr := d.applyFixups(true); ferr != nil {
+		return ferr
+	}
+	if d.end() > end {
+		return nil
+	}
+	return err
+}
+
+// settle stores any pending data, along with the labels and comments waiting on it, as
+// following the data decoded so far.
+func (d *Decoder) settle() {
+	end := d.end()
+	d.resolve(end)
+	d.storePending(end)
+}
+
+// add adds the contents of a decoded line.
+func (d *Decoder) add(ln *line) error {
+	if ln.dumpStart {
+		d.shift = 0
+	}
+	switch {
+	case ln.label != "":
+		d.resolv = append(d.resolv, unresolved{label: ln.label, isLabel: true, rel: d.pendLen})
+	case ln.hasComment:
+		d.resolv = append(d.resolv, unresolved{comment: ln.comment, rel: d.pendLen})
+	default:
+		if d.keepLiterals && ln.source != "" {
+			d.resolv = append(d.resolv, unresolved{comment: ln.source, rel: d.pendLen})
+		}
+		for i := range ln.refs {
+			r := ln.refs[i]
+			d.resolv = append(d.resolv, unresolved{ref: &r, rel: d.pendLen})
+		}
+		if !ln.hasOffset {
+			d.pend(ln.data, ln.mask, ln.fill)
+			return nil
+		}
+		pendOfs := ln.offset + d.shift - d.pendLen
+		if end := d.end(); pendOfs < end {
+			if d.scan.dialect == nil {
+				return fmt.Errorf("file contents attempted rewind, %X < %X", pendOfs, end)
 			}
-			return nil, err
+			// Another dump of the same offsets; move it past the data before it.
+			shift := (end - pendOfs + 16) &^ 15
+			d.shift += shift
+			pendOfs += shift
 		}
-		if label != "" {
-			resolv = append(resolv, unresolved{label, len(d.data)})
+		ofs := pendOfs + d.pendLen
+		d.resolve(pendOfs)
+		d.storePending(pendOfs)
+		d.store(chunk{ofs: ofs, data: ln.data, mask: ln.mask, fill: ln.fill})
+		return d.applyFixups(false)
+	}
+	return nil
+}
+
+// resolve assigns offsets to any labels, comments and references waiting on one, now that
+// we know the data pending alongside them starts at ofs.
+func (d *Decoder) resolve(ofs int64) {
+	for _, u := range d.resolv {
+		switch {
+		case u.ref != nil:
+			d.fixups = append(d.fixups, fixup{ofs + u.rel + int64(u.ref.pos), *u.ref})
+		case u.isLabel:
+			d.labels.Set(u.label, ofs+u.rel)
+		default:
+			d.comments.Add(ofs+u.rel, u.comment)
+		}
+	}
+	d.resolv = d.resolv[:0]
+}
+
+// applyFixups fills in the bytes for each reference whose labels are all defined.  If final
+// is set, no more labels are coming, and an undefined label is an error.
+func (d *Decoder) applyFixups(final bool) error {
+	kept := d.fixups[:0]
+	for _, f := range d.fixups {
+		v, err := f.ref.x.eval(&d.labels)
+		if errors.Is(err, errUndefined) && !final {
+			kept = append(kept, f)
 			continue
 		}
-		if hasOfs {
-			pendOfs := lineOfs - int64(len(pending))
-			for len(resolv) > 0 {
-				d.labels.Set(resolv[0].label, int64(resolv[0].rel)+pendOfs)
-				resolv = resolv[1:]
-			}
-			if pendOfs < d.readyOfs+int64(len(d.data)) {
-				return nil, fmt.Errorf("file contents attempted rewind, %X < %X", pendOfs, d.readyOfs)
-			}
-			if !d.started || pendOfs > d.readyOfs+int64(len(d.data)) {
-				d.nextData = append(pending, data...)
-				d.nextOffset = pendOfs
-				return nil, nil
-			}
-			data = append(pending, data...)
-			d.readyOfs = pendOfs //lineOfs + int64(len(data))
-		} else {
-			pending = append(pending, data...)
-			data = nil
+		if err == nil {
+			err = f.ref.typ.put(d.at(f.ofs, f.ref.typ.size), v)
+		}
+		if err != nil {
+			// Don't let anyone read data we couldn't complete.
+			d.buf, d.bufMask, d.queue, d.fixups = d.buf[:d.pos], d.bufMask[:0], nil, nil
+			return fmt.Errorf("%X: %s(%s): %v", f.ofs, f.ref.typ.name, f.ref.x, err)
 		}
 	}
-	return
+	d.fixups = kept
+	return nil
+}
+
+// at returns the n bytes of decoded data at ofs, which must not have been read yet.
+func (d *Decoder) at(ofs int64, n int) []byte {
+	for i := len(d.queue) - 1; i >= 0; i-- {
+		if c := d.queue[i]; ofs >= c.ofs {
+			return c.data[ofs-c.ofs:][:n]
+		}
+	}
+	i := d.pos + int(ofs-d.readyOfs)
+	return d.buf[i : i+n]
 }
 
 // Labels returns a container of all labels decoded from the hexdump input.
 // The returned instance is live and will reflect changes as the decoding
 // process occurs.  Labels will be available before calls to Read are satisfied,
 // meaning this can be connected directly to a Dumper instance and labels
-// will be copied as expected.
+// will be copied as expected, even if the Dumper runs in another goroutine.
 func (d *Decoder) Labels() *Labels {
 	return &d.labels
 }
+
+// Comments returns a container of all whole-line comments decoded from the
+// hexdump input, keyed by the offset of the data following them.  Like Labels,
+// the returned instance is live and is populated as decoding occurs.
+func (d *Decoder) Comments() *Comments {
+	return &d.comments
+}
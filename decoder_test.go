@@ -1,6 +1,7 @@
 package lhex_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -156,3 +157,295 @@ func ExampleDecoder_sparseBuffer() {
 	// Output:
 	// Got 16 bytes at offset 0x120
 }
+
+func TestDecodeComments(t *testing.T) {
+	input := `# header
+0010  00 01 02 03 04 05 06 07  08 09 0A 0B 0C 0D 0E 0F  |................|
+#
+#  indented
+0020  10 11 12 13                                       |....|
+# trailing
+:end
+`
+	d := lhex.NewDecoder(strings.NewReader(input))
+	if _, err := sparse.Copy(&sparse.Buffer{}, d); err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	c := d.Comments()
+	if got := c.Get(0x10); len(got) != 1 || got[0] != "header" {
+		t.Errorf("comment at 0x10 should be [header], got %q", got)
+	}
+	if got := c.Get(0x20); len(got) != 2 || got[0] != "" || got[1] != " indented" {
+		t.Errorf("comments at 0x20 should be [\"\" \" indented\"], got %q", got)
+	}
+	if got := c.Get(0x24); len(got) != 1 || got[0] != "trailing" {
+		t.Errorf("comment at 0x24 should be [trailing], got %q", got)
+	}
+	if ofs, ok := d.Labels().Get("end"); !ok || ofs != 0x24 {
+		t.Errorf("label at the end of the input should be at 0x24, got 0x%X, %v", ofs, ok)
+	}
+	var got []string
+	for it := c.Iter(0x11); it.Ofs >= 0; it.Next() {
+		got = append(got, fmt.Sprintf("%X:%q", it.Ofs, it.Comments))
+	}
+	if s := strings.Join(got, " "); s != `20:["" " indented"] 24:["trailing"]` {
+		t.Errorf("Iter(0x11) should visit the comments at 0x20 and 0x24, got %s", s)
+	}
+}
+
+func TestDecodeLabelDigits(t *testing.T) {
+	d := lhex.NewDecoder(strings.NewReader(":l10\n0010  00\n"))
+	if _, err := sparse.Copy(&sparse.Buffer{}, d); err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if ofs, ok := d.Labels().Get("l10"); !ok || ofs != 0x10 {
+		t.Errorf("label with digits should be at 0x10, got 0x%X, %v", ofs, ok)
+	}
+}
+
+func TestDecodeLongLine(t *testing.T) {
+	input := "# " + strings.Repeat("x", 10000) + "\n" +
+		"0010  00 01 02 03  |....|" + strings.Repeat(" ", 10000) + "\n" +
+		"0014  04 05 06 07  |....|\n"
+	d := lhex.NewDecoder(strings.NewReader(input))
+	if skip, err := d.Next(); skip != 0x10 || err != nil {
+		t.Fatalf("Next should give us skip 0x10 and err=nil, got 0x%X, %v", skip, err)
+	}
+	data, err := ioutil.ReadAll(d)
+	if len(data) != 8 || err != nil || data[7] != 7 {
+		t.Errorf("Reading should have given us 8 bytes, got %d bytes err=%v\n%s", len(data), err, hex.Dump(data))
+	}
+	if c := d.Comments().Get(0x10); len(c) != 1 || len(c[0]) != 10000 {
+		t.Errorf("long comment should have been decoded intact")
+	}
+}
+
+func TestNextDiscards(t *testing.T) {
+	input := `
+0000  00 01 02 03 04 05 06 07  08 09 0A 0B 0C 0D 0E 0F  |................|
+0010  10 11 12 13                                       |....|
+0020  20 21 22 23                                       | !"#|
+`
+	d := lhex.NewDecoder(strings.NewReader(input))
+	p := make([]byte, 2)
+	if n, err := d.Read(p); n != 2 || err != nil {
+		t.Fatalf("Read should give us 2 bytes, got %d, %v", n, err)
+	}
+	if skip, err := d.Next(); skip != 0xC || err != nil {
+		t.Fatalf("Next should discard the rest of the segment and skip 0xC, got 0x%X, %v", skip, err)
+	}
+	if n, err := d.Read(p); n != 2 || err != nil || p[0] != 0x20 {
+		t.Errorf("Read after Next should read from the next segment, got %d, %v, % X", n, err, p)
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next at the end of input should give io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeReferences(t *testing.T) {
+	input := `
+:start
+0000  u32le(body) u16be(end - body) 00 00
+0010  u8(start+7) i8(-1) u32be(0x01020304) u8(010)
+:body
+0100  DE AD BE EF
+:end
+`
+	f, err := lhex.Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	want := []lhex.Segment{
+		{Offset: 0x00, Data: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00}},
+		{Offset: 0x10, Data: []byte{0x07, 0xFF, 0x01, 0x02, 0x03, 0x04, 0x0A}},
+		{Offset: 0x100, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}},
+	}
+	if fmt.Sprint(f.Segments) != fmt.Sprint(want) {
+		t.Errorf("references resolved incorrectly\nwant %X\n got %X", want, f.Segments)
+	}
+
+	for _, tc := range []struct{ input, err string }{
+		{"0000  u32le(missing)\n", `undefined label "missing"`},
+		{"0000  u8(end)\n0200  00\n:end\n", "out of range"},
+		{"0000  u8(end-start)\n:start\n:end\n", `undefined label "end-start"`},
+		{"0000  u16le(foo\n", "expected ')'"},
+	} {
+		_, err := lhex.Decode(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("decoding %q should fail with %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+func TestDecodeLiterals(t *testing.T) {
+	input := `0000  "Hi\t\"x\"\x00" 7F u16be(0x1234) i32le(-2)
+0020  "#|" 00
+`
+	d := lhex.NewDecoder(strings.NewReader(input))
+	d.SetKeepLiterals(true)
+	segs, err := lhex.ReadSegments(d)
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	want := []lhex.Segment{
+		{Offset: 0x00, Data: []byte("Hi\t\"x\"\x00\x7F\x12\x34\xFE\xFF\xFF\xFF")},
+		{Offset: 0x20, Data: []byte("#|\x00")},
+	}
+	if fmt.Sprint(segs) != fmt.Sprint(want) {
+		t.Errorf("literals decoded incorrectly\nwant %X\n got %X", want, segs)
+	}
+	if got := d.Comments().Get(0x20); len(got) != 1 || got[0] != `0020  "#|" 00` {
+		t.Errorf("SetKeepLiterals should keep the source line as a comment, got %q", got)
+	}
+
+	for _, tc := range []struct{ input, err string }{
+		{"0000  \"abc\n", "unterminated string"},
+		{"0000  \"\\q\"\n", "invalid string"},
+		{"0000  u16le(0x10000)\n", "out of range"},
+	} {
+		_, err := lhex.Decode(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("decoding %q should fail with %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+func TestDecodeFill(t *testing.T) {
+	input := `
+0000  01 02
+:pad
+      .fill 5 AA BB
+0007  03
+:big
+0100  .fill 0x100000000
+:after
+0100000100  04
+`
+	d := lhex.NewDecoder(strings.NewReader(input))
+	data, err := ioutil.ReadAll(d)
+	if want := []byte{1, 2, 0xAA, 0xBB, 0xAA, 0xBB, 0xAA, 3}; !bytes.Equal(data, want) || err != nil {
+		t.Errorf("fill pattern should repeat, want % X, got % X, %v", want, data, err)
+	}
+	if ofs, _ := d.Labels().Get("pad"); ofs != 2 {
+		t.Errorf("label before fill should be at 2, got 0x%X", ofs)
+	}
+	if skip, err := d.Next(); skip != 0xF8 || err != nil {
+		t.Fatalf("Next should skip to the fill, got 0x%X, %v", skip, err)
+	}
+	p := make([]byte, 3)
+	if n, err := d.Read(p); n != 3 || err != nil || !bytes.Equal(p, []byte{0, 0, 0}) {
+		t.Errorf("Read from the fill should give zeros, got %d, %v, % X", n, err, p)
+	}
+
+	// Next shouldn't have to generate the rest of the fill to skip past it, and the data
+	// following it is contiguous.
+	if skip, err := d.Next(); err != io.EOF {
+		t.Errorf("Next should reach the end of input, got 0x%X, %v", skip, err)
+	}
+	if ofs, _ := d.Labels().Get("after"); ofs != 0x100000100 {
+		t.Errorf("label after fill should be at 0x100000100, got 0x%X", ofs)
+	}
+
+	// Lengths are decimal, even with a leading zero.
+	if f, err := lhex.Decode(strings.NewReader("0000  .fill 010\n")); err != nil || len(f.Segments) != 1 || len(f.Segments[0].Data) != 10 {
+		t.Errorf(".fill 010 should be 10 bytes, got %v, %v", f, err)
+	}
+
+	for _, tc := range []struct{ input, err string }{
+		{"0000  .repeat 4 00\n", "unknown directive"},
+		{"0000  .fill x 00\n", "invalid .fill length"},
+		{"0000  .fill 4 00 zz\n", "illegal text"},
+	} {
+		_, err := lhex.Decode(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("decoding %q should fail with %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+func TestDecodeWildcards(t *testing.T) {
+	input := `
+0000  01 ?? 4? ?F 05
+0010  ?? ??
+`
+	d := lhex.NewDecoder(strings.NewReader(input))
+	p, mask := make([]byte, 8), make([]byte, 8)
+	n, err := d.ReadMasked(p, mask)
+	if n != 5 || err != nil {
+		t.Fatalf("ReadMasked should give us 5 bytes, got %d, %v", n, err)
+	}
+	if want := []byte{1, 0, 0x40, 0x0F, 5}; !bytes.Equal(p[:n], want) {
+		t.Errorf("wildcard bits should read as 0, want % X, got % X", want, p[:n])
+	}
+	if want := []byte{0xFF, 0, 0xF0, 0x0F, 0xFF}; !bytes.Equal(mask[:n], want) {
+		t.Errorf("mask should mark wildcard bits, want % X, got % X", want, mask[:n])
+	}
+
+	f, err := lhex.Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if f.Segments[0].Mask == nil || f.Segments[1].Mask == nil {
+		t.Errorf("segments with wildcards should have masks, got %v", f.Segments)
+	}
+	if f, _ := lhex.Decode(strings.NewReader("0000  01 02\n")); f.Segments[0].Mask != nil {
+		t.Errorf("segments without wildcards should not have a mask, got % X", f.Segments[0].Mask)
+	}
+
+	for _, tc := range []struct{ input, err string }{
+		{"0000  ???\n", "too many characters"},
+		{"0000  ?x\n", "illegal character"},
+		{"0000  .fill 4 ??\n", "illegal text"},
+	} {
+		_, err := lhex.Decode(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("decoding %q should fail with %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+// benchDump is a hex dump of several megabytes of contiguous data.
+var benchDump = []byte(lhex.Dump(benchData(1<<22), 0, nil))
+
+// benchUnaddressed is a hex dump where only the final line has an offset, which requires the
+// decoder to hold all of the data until it reaches the end.
+var benchUnaddressed = func() []byte {
+	var buf bytes.Buffer
+	lines := strings.SplitAfter(lhex.Dump(benchData(1<<18), 0, nil), "\n")
+	for i, l := range lines {
+		if i < len(lines)-2 {
+			l = "        " + l[8:]
+		}
+		buf.WriteString(l)
+	}
+	return buf.Bytes()
+}()
+
+func benchData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func benchmarkDecoder(b *testing.B, input []byte, chunk int) {
+	p := make([]byte, chunk)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := lhex.NewDecoder(bytes.NewReader(input))
+		for {
+			if _, err := d.Read(p); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecoderByte(b *testing.B)            { benchmarkDecoder(b, benchDump, 1) }
+func BenchmarkDecoderBulk(b *testing.B)            { benchmarkDecoder(b, benchDump, 1<<16) }
+func BenchmarkDecoderUnaddressedByte(b *testing.B) { benchmarkDecoder(b, benchUnaddressed, 1) }
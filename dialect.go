@@ -0,0 +1,114 @@
+package lhex
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Dialect describes hex dumps embedded in other output, such as log files, so that a Decoder
+// can pull them out.  Lines that aren't part of a dump are skipped, and end the dump before
+// them.  Since a log may hold several dumps of the same offsets, a dump whose offsets would go
+// back over data already decoded is moved forward past it, by a multiple of 16 bytes, leaving
+// a gap so that it reads as a new segment.
+type Dialect struct {
+	// Prefix, if not nil, matches text to strip from the start of each line, like a
+	// timestamp.  Lines it doesn't match at their start are skipped.
+	Prefix *regexp.Regexp
+
+	// Line, if not nil, matches a line of a dump in some other format, after Prefix is
+	// stripped.  Its first submatch gives the line's offset in hex, and its second the bytes
+	// of data on the line, in hex, separated by spaces and optionally prefixed with "0x".
+	// Lines it doesn't match are skipped.  If the first submatch is empty, the line has no
+	// offset, and its data follows the data before it.
+	//
+	// If Line is nil, what follows the prefix is read as lhex, and lines that can't be read
+	// that way are skipped.
+	Line *regexp.Regexp
+
+	// Rebase, if set, makes the offsets matched by Line relative to the first offset of each
+	// dump, for dumps giving memory addresses that may not fit in an offset.
+	Rebase bool
+}
+
+// Built-in dialects.
+var (
+	// KernelHexDump reads dumps written by the Linux kernel's print_hex_dump with
+	// DUMP_PREFIX_OFFSET or DUMP_PREFIX_ADDRESS and a group size of 1, as they appear in dmesg
+	// output or the system log, like:
+	//
+	//	[   12.345678] usb 1-1: 00000000: 48 65 6c 6c 6f 0a 00 01  Hello...
+	//
+	// Offsets are relative to the start of each dump, so kernel addresses read as offsets
+	// from the first line.
+	KernelHexDump = &Dialect{
+		Line:   regexp.MustCompile(`(?:^|\s)([0-9a-f]{8}|[0-9a-f]{16}): ((?:[0-9a-f]{2} )*[0-9a-f]{2})(?:  |\s*$)`),
+		Rebase: true,
+	}
+
+	// GDB reads dumps written by gdb's "x/16xb" command, like:
+	//
+	//	0x601040 <buf>:	0x48	0x65	0x6c	0x6c	0x6f	0x0a	0x00	0x01
+	GDB = &Dialect{
+		Line: regexp.MustCompile(`^0x([0-9a-f]+)(?: <[^>]*>)?:((?:\s+0x[0-9a-f]{2})+)\s*$`),
+	}
+)
+
+// SetDialect causes the Decoder to read hex dumps embedded in other output as described by
+// dialect, skipping lines that aren't part of them.  A nil dialect reads plain lhex.  This
+// should be called before the first Read.
+func (d *Decoder) SetDialect(dialect *Dialect) {
+	d.scan.dialect = dialect
+}
+
+// applyDialect rewrites d.line according to d.dialect, returning false if it should be
+// skipped.
+func (d *scanner) applyDialect() (bool, error) {
+	if re := d.dialect.Prefix; re != nil {
+		loc := re.FindIndex(d.line)
+		if loc == nil || loc[0] != 0 {
+			return false, nil
+		}
+		d.line = d.line[loc[1]:]
+	}
+	re := d.dialect.Line
+	if re == nil {
+		return true, nil
+	}
+	m := re.FindSubmatch(d.line)
+	if m == nil {
+		return false, nil
+	}
+	buf := d.rewritten[:0]
+	if len(m[1]) > 0 && d.dialect.Rebase {
+		ofs, err := strconv.ParseUint(string(m[1]), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid offset %q in %q", m[1], bytes.TrimRight(d.line, "\r\n"))
+		}
+		if !d.based || ofs < d.base {
+			// An offset going backwards starts another dump.
+			d.base, d.based, d.inDump = ofs, true, false
+		}
+		var num [16]byte
+		m[1] = strconv.AppendUint(num[:0], ofs-d.base, 16)
+	}
+	if len(m[1]) > 0 {
+		if len(m[1]) < 8 {
+			buf = append(buf, "00000000"[len(m[1]):]...)
+		} else if len(m[1])%2 != 0 {
+			buf = append(buf, '0')
+		}
+		buf = append(buf, bytes.ToUpper(m[1])...)
+	}
+	for _, tok := range bytes.Fields(m[2]) {
+		tok = bytes.TrimPrefix(tok, []byte("0x"))
+		if len(tok) != 2 {
+			return false, fmt.Errorf("invalid byte %q in %q", tok, bytes.TrimRight(d.line, "\r\n"))
+		}
+		buf = append(append(buf, ' '), bytes.ToUpper(tok)...)
+	}
+	d.rewritten = append(buf, '\n')
+	d.line = d.rewritten
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package lhex_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func decodeDialect(t *testing.T, dialect *lhex.Dialect, input string) []lhex.Segment {
+	t.Helper()
+	dec := lhex.NewDecoder(strings.NewReader(input))
+	dec.SetDialect(dialect)
+	segs, err := lhex.ReadSegments(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segs
+}
+
+func TestDialectPrefix(t *testing.T) {
+	input := `2024-05-01 12:00:00 INFO starting
+2024-05-01 12:00:01 DEBUG :greeting
+2024-05-01 12:00:01 DEBUG 00000000  48 65 6C 6C 6F 0A                                 |Hello.|
+2024-05-01 12:00:02 INFO Added 3 items
+2024-05-01 12:00:02 DEBUG 00000010  "bye"
+`
+	dialect := &lhex.Dialect{Prefix: regexp.MustCompile(`^\S+ \S+ DEBUG `)}
+	dec := lhex.NewDecoder(strings.NewReader(input))
+	dec.SetDialect(dialect)
+	segs, err := lhex.ReadSegments(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 2 || string(segs[0].Data) != "Hello\n" || segs[1].Offset != 0x10 || string(segs[1].Data) != "bye" {
+		t.Errorf("decoded %+v", segs)
+	}
+	if ofs, ok := dec.Labels().Get("greeting"); !ok || ofs != 0 {
+		t.Errorf("label greeting = %X, %v, want 0", ofs, ok)
+	}
+
+	// Without a Line pattern, lines that aren't lhex are skipped too.
+	segs = decodeDialect(t, &lhex.Dialect{Prefix: regexp.MustCompile(`^\S+ \S+ \S+ `)}, input)
+	if len(segs) != 2 || string(segs[0].Data) != "Hello\n" {
+		t.Errorf("decoded %+v", segs)
+	}
+}
+
+func TestDialectKernel(t *testing.T) {
+	input := `[   12.345670] usb 1-1: new high-speed USB device number 2
+[   12.345678] usb 1-1: 00000000: 48 65 6c 6c 6f 2c 20 77 6f 72 6c 64 0a 00 01 02  Hello, world....
+[   12.345679] usb 1-1: 00000010: ab cd                                            ..
+[   12.345680] usb 1-1: 00000040: 7f                                               .
+`
+	segs := decodeDialect(t, lhex.KernelHexDump, input)
+	if len(segs) != 2 || string(segs[0].Data) != "Hello, world\n\x00\x01\x02\xAB\xCD" || segs[1].Offset != 0x40 || segs[1].Data[0] != 0x7F {
+		t.Errorf("decoded %+v", segs)
+	}
+}
+
+func TestDialectKernelAddress(t *testing.T) {
+	input := `[   12.345678] buf: ffff888003a1c000: 48 65 6c 6c 6f 0a 00 01 02 03 04 05 06 07 08 09  Hello...........
+[   12.345679] buf: ffff888003a1c010: ab cd                                            ..
+`
+	segs := decodeDialect(t, lhex.KernelHexDump, input)
+	if len(segs) != 1 || segs[0].Offset != 0 || string(segs[0].Data) != "Hello\n\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\xAB\xCD" {
+		t.Errorf("addresses should be read relative to the first line, decoded %+v", segs)
+	}
+}
+
+func TestDialectSeveralDumps(t *testing.T) {
+	input := `[   12.345678] usb 1-1: 00000000: 48 65 6c 6c 6f 2c 20 77 6f 72 6c 64 0a 00 01 02  Hello, world....
+[   12.345679] usb 1-1: 00000010: ab cd                                            ..
+[   12.345680] usb 1-1: reset high-speed USB device number 2
+[   12.345681] usb 1-1: 00000000: 01 02 03                                         ...
+[   12.345682] buf: dumping
+[   12.345683] buf: ffff888003a1c008: 7f                                               .
+`
+	segs := decodeDialect(t, lhex.KernelHexDump, input)
+	if len(segs) != 3 || segs[0].Offset != 0 || len(segs[0].Data) != 0x12 ||
+		segs[1].Offset != 0x20 || string(segs[1].Data) != "\x01\x02\x03" ||
+		segs[2].Offset != 0x30 || string(segs[2].Data) != "\x7F" {
+		t.Errorf("each dump should follow the one before it, decoded %+v", segs)
+	}
+
+	// Without Rebase, a later dump of lower addresses is moved as well.
+	input = `(gdb) x/2xb 0x601040
+0x601040:	0x48	0x65
+(gdb) x/2xb 0x601030
+0x601030:	0x6c	0x6c
+0x601038:	0x6f
+`
+	segs = decodeDialect(t, lhex.GDB, input)
+	if len(segs) != 3 || segs[0].Offset != 0x601040 || segs[1].Offset != 0x601050 || segs[2].Offset != 0x601058 {
+		t.Errorf("a dump going back over earlier data should be moved past it, decoded %+v", segs)
+	}
+}
+
+func TestDialectGDB(t *testing.T) {
+	input := `(gdb) x/16xb buf
+0x601040 <buf>:	0x48	0x65	0x6c	0x6c	0x6f	0x0a	0x00	0x01
+0x601048 <buf+8>:	0x02	0x03	0x04	0x05	0x06	0x07	0x08	0x09
+(gdb) x/2xb 0x60105d
+0x60105d:	0xff	0xee
+`
+	segs := decodeDialect(t, lhex.GDB, input)
+	if len(segs) != 2 || segs[0].Offset != 0x601040 || string(segs[0].Data) != "Hello\n\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09" ||
+		segs[1].Offset != 0x60105D || string(segs[1].Data) != "\xFF\xEE" {
+		t.Errorf("decoded %+v", segs)
+	}
+}
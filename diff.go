@@ -0,0 +1,263 @@
+package lhex
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type diffKind int
+
+const (
+	diffChanged diffKind = iota
+	diffAdded
+	diffRemoved
+)
+
+func (k diffKind) String() string {
+	switch k {
+	case diffChanged:
+		return "changed"
+	case diffAdded:
+		return "added"
+	default:
+		return "removed"
+	}
+}
+
+// diffRange describes a run of bytes that differs between two Files.
+type diffRange struct {
+	start, end int64
+	kind       diffKind
+}
+
+// Diff writes to w a hex dump describing how b differs from a.  Offsets in a and b are aligned
+// with each other, and only the lines of b containing differences are written.  Comments
+// preceding each difference note whether the bytes were changed, added to b, or removed from a,
+// and give the original bytes from a where they differ.  Labels from both a and b are
+// included, with comments noting any label that moved or exists on only one side.  Nothing is
+// written if a and b are identical.
+//
+// The output is itself a valid hex dump, containing the whole lines of b that hold differences.
+// Where b has no data, as where bytes were removed, only the comments are written.
+func Diff(w io.Writer, a, b *File) error {
+	ranges := diffData(a, b)
+	labels, notes := diffLabels(a.Labels, b.Labels)
+
+	// Comments at offsets where b has data go ahead of that data.  The rest are written on
+	// their own, in order.
+	var comments Comments
+	var orphans []diffNote
+	add := func(ofs int64, text string) {
+		if b.find(ofs) >= 0 {
+			comments.Add(ofs, text)
+		} else {
+			orphans = append(orphans, diffNote{ofs, text})
+		}
+	}
+	for _, r := range ranges {
+		add(r.start, fmt.Sprintf("%s %08X-%08X", r.kind, r.start, r.end))
+		if r.kind != diffAdded {
+			for _, s := range a.slice(r.start, r.end) {
+				for _, l := range strings.SplitAfter(Dump(s.Data, s.Offset, nil), "\n") {
+					if l != "" {
+						add(r.start, "- "+strings.TrimSuffix(l, "\n"))
+					}
+				}
+			}
+		}
+	}
+	for _, n := range notes {
+		add(n.ofs, n.text)
+	}
+	sort.SliceStable(orphans, func(i, j int) bool { return orphans[i].ofs < orphans[j].ofs })
+
+	// Pieces of b are separated by blank lines, as are the comments starting a hunk from
+	// whatever came before.
+	var wrote, inHunk bool
+	separate := func(data bool) error {
+		var err error
+		if wrote && (data || !inHunk) {
+			_, err = io.WriteString(w, "\n")
+		}
+		wrote, inHunk = true, true
+		return err
+	}
+	writeOrphans := func(end int64) error {
+		for ; len(orphans) > 0 && orphans[0].ofs < end; orphans = orphans[1:] {
+			if err := separate(false); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "# %s\n", orphans[0].text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, h := range diffHunks(ranges, notes) {
+		inHunk = false
+		for _, p := range b.slice(h.start, h.end) {
+			if err := writeOrphans(p.Offset); err != nil {
+				return err
+			}
+			if err := separate(true); err != nil {
+				return err
+			}
+			dmp := NewDumper(w, labels)
+			dmp.SetComments(&comments)
+			if _, err := dmp.Seek(p.Offset, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := dmp.WriteMasked(p.Data, p.Mask); err != nil {
+				return err
+			}
+			if err := dmp.Close(); err != nil {
+				return err
+			}
+		}
+		if err := writeOrphans(h.end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slice returns the portions of f's segments that lie within [start, end).
+func (f *File) slice(start, end int64) (segs []Segment) {
+	for _, s := range f.Segments {
+		if s.End() <= start || s.Offset >= end {
+			continue
+		}
+		lo, hi := start, end
+		if lo < s.Offset {
+			lo = s.Offset
+		}
+		if hi > s.End() {
+			hi = s.End()
+		}
+		seg := Segment{Offset: lo, Data: s.Data[lo-s.Offset : hi-s.Offset]}
+		if s.Mask != nil {
+			seg.Mask = s.Mask[lo-s.Offset : hi-s.Offset]
+		}
+		segs = append(segs, seg)
+	}
+	return
+}
+
+// diffData compares the data in a and b, returning the ranges that differ in order.
+func diffData(a, b *File) (ranges []diffRange) {
+	add := func(start, end int64, kind diffKind) {
+		if n := len(ranges); n > 0 && ranges[n-1].end == start && ranges[n-1].kind == kind {
+			ranges[n-1].end = end
+			return
+		}
+		ranges = append(ranges, diffRange{start, end, kind})
+	}
+
+	// Between any two adjacent segment boundaries, each side either has data or it doesn't.
+	var bounds []int64
+	for _, f := range []*File{a, b} {
+		for _, s := range f.Segments {
+			bounds = append(bounds, s.Offset, s.End())
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	for i := 0; i+1 < len(bounds); i++ {
+		lo, hi := bounds[i], bounds[i+1]
+		if lo == hi {
+			continue
+		}
+		ai, bi := a.find(lo), b.find(lo)
+		switch {
+		case ai < 0 && bi < 0:
+		case bi < 0:
+			add(lo, hi, diffRemoved)
+		case ai < 0:
+			add(lo, hi, diffAdded)
+		default:
+			as, bs := a.Segments[ai], b.Segments[bi]
+			ad := as.Data[lo-as.Offset : hi-as.Offset]
+			bd := bs.Data[lo-bs.Offset : hi-bs.Offset]
+			for j := 0; j < len(ad); j++ {
+				// Wildcard bits on either side match anything.
+				mask := maskAt(as.Mask, int(lo-as.Offset)+j) & maskAt(bs.Mask, int(lo-bs.Offset)+j)
+				if !matches(ad[j], bd[j], mask) {
+					add(lo+int64(j), lo+int64(j)+1, diffChanged)
+				}
+			}
+		}
+	}
+	return
+}
+
+// diffNote is a comment describing a label difference.
+type diffNote struct {
+	ofs  int64
+	text string
+}
+
+// diffLabels merges the labels from a and b, preferring b's offset for labels found in both,
+// and describes any differences between them.
+func diffLabels(a, b *Labels) (merged *Labels, notes []diffNote) {
+	am, bm := a.All(), b.All()
+	names := make([]string, 0, len(am)+len(bm))
+	for name := range am {
+		names = append(names, name)
+	}
+	for name := range bm {
+		if _, ok := am[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	merged = &Labels{}
+	for _, name := range names {
+		ao, aok := am[name]
+		bo, bok := bm[name]
+		switch {
+		case !bok:
+			merged.Set(name, ao)
+			notes = append(notes, diffNote{ao, fmt.Sprintf("label %s removed", name)})
+		case !aok:
+			merged.Set(name, bo)
+			notes = append(notes, diffNote{bo, fmt.Sprintf("label %s added", name)})
+		default:
+			merged.Set(name, bo)
+			if ao != bo {
+				notes = append(notes, diffNote{bo, fmt.Sprintf("label %s moved from %08X", name, ao)})
+			}
+		}
+	}
+	return merged, notes
+}
+
+// diffHunks expands each difference to whole lines, merging any that overlap, so that
+// differences are shown with the rest of the lines they appear in.
+func diffHunks(ranges []diffRange, notes []diffNote) (hunks []diffRange) {
+	for _, r := range ranges {
+		hunks = append(hunks, diffRange{start: r.start, end: r.end})
+	}
+	for _, n := range notes {
+		hunks = append(hunks, diffRange{start: n.ofs, end: n.ofs + 1})
+	}
+	for i := range hunks {
+		hunks[i].start &^= 0xF
+		hunks[i].end = (hunks[i].end + 0xF) &^ 0xF
+	}
+	sort.Slice(hunks, func(i, j int) bool { return hunks[i].start < hunks[j].start })
+
+	var merged []diffRange
+	for _, h := range hunks {
+		if n := len(merged); n > 0 && h.start <= merged[n-1].end {
+			if h.end > merged[n-1].end {
+				merged[n-1].end = h.end
+			}
+			continue
+		}
+		merged = append(merged, h)
+	}
+	return merged
+}
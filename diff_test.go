@@ -0,0 +1,110 @@
+package lhex_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := lhex.Decode(strings.NewReader(`
+:start
+00000000  00 01 02 03 04 05 06 07  08 09 0A 0B 0C 0D 0E 0F  |................|
+00000010  10 11 12 13 14 15 16 17  18 19 1A 1B 1C 1D 1E 1F  |................|
+:gone
+00000020  20 21 22 23                                       | !"#|
+:moved
+00000030  30 31                                             |01|
+00000200  50 51                                             |PQ|
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := lhex.Decode(strings.NewReader(`
+:start
+00000000  00 01 02 03 04 05 06 07  08 09 0A 0B 0C 0D 0E 0F  |................|
+00000010  10 11 2A 2B 14 15 16 17  18 19 1A 1B 1C 1D 1E 1F  |................|
+00000030  30                                                |0|
+:moved
+00000031  31                                                |1|
+:new
+00000100  40 41 42 43                                       |@ABC|
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := lhex.Diff(&buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, "diff", buf, `
+00000010  10 11                                             |..|
+# changed 00000012-00000014
+# - 00000012  12 13                                             |..|
+                2A 2B 14 15 16 17  18 19 1A 1B 1C 1D 1E 1F    |*+............|
+:gone
+00000020                                                    ||
+# removed 00000020-00000024
+# - 00000020  20 21 22 23                                       | !"#|
+# label gone removed
+
+00000030  30                                                |0|
+# label moved moved from 00000030
+:moved
+00000031  31                                                |1|
+
+# added 00000100-00000104
+# label new added
+:new
+00000100  40 41 42 43                                       |@ABC|
+
+# removed 00000200-00000202
+# - 00000200  50 51                                             |PQ|`)
+
+	// The diff should itself decode to the bytes that differ in b.
+	patch, err := lhex.Decode(&buf)
+	if err != nil {
+		t.Fatalf("diff output should decode: %v", err)
+	}
+	if len(patch.Segments) != 3 || string(patch.Segments[0].Data[2:4]) != "*+" {
+		t.Errorf("diff output should decode to the segments of b that changed, got %+v", patch.Segments)
+	}
+
+	buf.Reset()
+	if err := lhex.Diff(&buf, a, a); err != nil || buf.Len() != 0 {
+		t.Errorf("diff of identical files should be empty, got %v:\n%s", err, buf.String())
+	}
+}
+
+func TestDiffWildcards(t *testing.T) {
+	a, err := lhex.Decode(strings.NewReader("00000000  00 01 02 03\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := lhex.Decode(strings.NewReader("00000000  00 ?? 0? 13\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := lhex.Diff(&buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, "wildcards", buf, `
+00000000  00 ?? 0?                                          |.??|
+# changed 00000003-00000004
+# - 00000003  03                                                |.|
+00000003  13                                                |.|`)
+
+	c, err := lhex.Decode(strings.NewReader("00000000  ?? 01 0? 03\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := lhex.Diff(&buf, a, c); err != nil || buf.Len() != 0 {
+		t.Errorf("wildcards should match anything, got %v:\n%s", err, buf.String())
+	}
+}
@@ -12,13 +12,16 @@ import (
 // current offset is, and disallow an attempt to change it inappropriately.  Not honoring these
 // rules leads to bugs.
 type dataBuf struct {
-	ofs  int64
-	data [16]byte
-	have int
+	ofs    int64
+	data   [16]byte
+	mask   [16]byte // only valid if masked
+	masked bool     // whether any of data is masked
+	have   int
 }
 
-// fill tries to bring len(data) up to want by copying bytes from p.
-func (b *dataBuf) fill(p []byte, want int) (n int) {
+// fill tries to bring len(data) up to want by copying bytes from p, along with their masks if
+// mask is not nil.
+func (b *dataBuf) fill(p, mask []byte, want int) (n int) {
 	if want > len(b.data) {
 		panic("want more than data capacity")
 	}
@@ -26,16 +29,34 @@ func (b *dataBuf) fill(p []byte, want int) (n int) {
 		panic("want negative")
 	}
 	n = copy(b.data[b.have:want], p)
+	if mask != nil {
+		if !b.masked {
+			for i := 0; i < b.have; i++ {
+				b.mask[i] = 0xFF
+			}
+		}
+		copy(b.mask[b.have:b.have+n], mask)
+		b.masked = true
+	} else if b.masked {
+		for i := b.have; i < b.have+n; i++ {
+			b.mask[i] = 0xFF
+		}
+	}
 	b.have += n
 	return n
 }
 
 // take empties the buffer, returns its contents, and advances the canonical offset by len(data).
-func (b *dataBuf) take() (ofs int64, data []byte) {
+// The returned mask is nil if none of the data is masked.
+func (b *dataBuf) take() (ofs int64, data, mask []byte) {
 	ofs = b.ofs
 	data = b.data[:b.have]
+	if b.masked {
+		mask = b.mask[:b.have]
+	}
 	b.ofs += int64(b.have)
 	b.have = 0
+	b.masked = false
 	return
 }
 
@@ -52,27 +73,108 @@ func (b *dataBuf) set(ofs int64) {
 // optional labels at offsets in the data.  This type also has a Seek method that can be used to
 // change the offset reported in the hex dump.
 type Dumper struct {
-	closed bool // Close() was called
-	w      io.Writer
+	closed bool       // Close() was called
+	w      *errWriter // the wrapped writer, keeping the first error writing to it
 
 	nextOff       int64 // Seek request that gets honored during Write via honorSeekIfNeeded.
 	writePending  bool  // Write was called, which implies intent to write something
 	wroteAnything bool  // Once we start writing, we start emitting blank lines between sections.
 
-	labels    *Labels
-	labelIter *labelIter
-	data      dataBuf
+	labels      *Labels
+	labelIter   LabelIter
+	comments    *Comments
+	commentIter LabelIter
+	data        dataBuf
+	line        []byte // buffer for writeLine, reused between lines
+
+	relative      bool // whether to include a column of offsets relative to labels
+	relativeWidth int  // minimum width of that column
+
+	fillMin int   // shortest run of identical bytes to write as a fill directive, or 0
+	run     int64 // length of the run of runByte seen starting at data.ofs
+	runByte byte
+
+	redactions []*redaction // regions to redact, ordered by offset
+	redactKey  []byte       // key for the HMAC of redacted bytes, or nil for none
 }
 
 // NewDumper creates a Dumper writing to w, optionally writing labels where appropriate.
 func NewDumper(w io.Writer, labels *Labels) *Dumper {
 	//gotrace.Log("NewDumper(%v)", labels)
-	return &Dumper{w: w, labels: labels, labelIter: labels.iter(0)}
+	d := &Dumper{w: &errWriter{w: w}, labels: labels, labelIter: labels.iter(0), line: make([]byte, 0, lineCap)}
+	d.commentIter = d.comments.iter(0)
+	return d
+}
+
+// SetRelative controls whether lines having an offset also include that offset relative to
+// the nearest preceding label, in the form "<foo+0x12>", as produced by Labels.Symbolize.  The
+// Decoder ignores this column.  The column is wide enough for the longest label name with a
+// distance of up to 8 hex digits, and widens should a line need more.
+func (d *Dumper) SetRelative(relative bool) {
+	d.relative = relative
+	d.relativeWidth = 0
+	if relative {
+		for name := range d.labels.All() {
+			if len(name) > d.relativeWidth {
+				d.relativeWidth = len(name)
+			}
+		}
+		d.relativeWidth += len("<+0x00000000>")
+	}
 }
 
-// If the current offset is the same as the next label, write any labels pointing to this
-// offset, ordered by label.
+// SetFill arranges for runs of at least min identical bytes, starting at the beginning of a
+// line, to be written as a ".fill" directive rather than as lines of hex.  A min of 0 disables
+// this.  Runs are broken at labels and comments, and written in whole lines, with any
+// remainder written normally.
+func (d *Dumper) SetFill(min int) {
+	if min > 0 && min < 0x10 {
+		min = 0x10
+	}
+	d.fillMin = min
+}
+
+// SetComments arranges for comment lines from comments to be emitted ahead of the data at
+// their offsets, much like labels.  This should be called before the first Write.
+func (d *Dumper) SetComments(comments *Comments) {
+	d.comments = comments
+	d.commentIter = comments.iter(d.data.ofs)
+}
+
+// refreshAnnotations updates our view of the labels and comments up to until, since they may
+// be added concurrently, skipping any that were added too late, behind the current offset.
+// This is done once per Write, rather than for every line.
+func (d *Dumper) refreshAnnotations(until int64) {
+	d.labelIter.sync(d.data.ofs, until)
+	d.commentIter.sync(d.data.ofs, until)
+}
+
+// nextAnnotation returns the offset of the next label or comment, or <0 if there are none.
+func (d *Dumper) nextAnnotation() int64 {
+	next := d.labelIter.Ofs
+	if c := d.commentIter.Ofs; c >= 0 && (next < 0 || c < next) {
+		next = c
+	}
+	if r := d.nextRedactionEnd(); r >= 0 && (next < 0 || r < next) {
+		next = r
+	}
+	return next
+}
+
+// If the current offset is the same as the next comment or label, write any comments and labels
+// pointing to this offset, with labels ordered by label.
 func (d *Dumper) writeLabelsIfNeeded() {
+	d.writeRedactionNotes(d.data.ofs)
+	if d.data.ofs == d.commentIter.Ofs {
+		for _, c := range d.commentIter.Labels {
+			if c == "" {
+				fmt.Fprintln(d.w, "#")
+			} else {
+				fmt.Fprintf(d.w, "# %s\n", c)
+			}
+		}
+		d.commentIter.Next()
+	}
 	if d.data.ofs == d.labelIter.Ofs {
 		for _, l := range d.labelIter.Labels {
 			fmt.Fprintf(d.w, ":%s\n", l)
@@ -84,41 +186,110 @@ func (d *Dumper) writeLabelsIfNeeded() {
 // If Seek was previously called, flush any pending data, emit a newline if needed, and
 // move us ahead to the seeked offset.
 func (d *Dumper) honorSeekIfNeeded() {
-	if d.data.ofs != d.nextOff {
+	if d.end() != d.nextOff {
 		d.wrapUp()
+		d.writeRedactionNotes(-1)
 		if d.wroteAnything {
 			fmt.Fprintln(d.w)
 		}
 		d.data.set(d.nextOff)
 		d.labelIter = d.labels.iter(d.nextOff)
+		d.commentIter = d.comments.iter(d.nextOff)
 	}
 }
 
 // Write continues writing a hex dump using input from p to the wrapped writer. This may trigger the
 // writing of any labels at the current offset (a Write with an empty or nil p might be appropriate
 // if you don't want to write any data with it).  Returns the number of bytes consumed from p and
-// whether an error was encountered writing the hex dump to the wrapped writer.
+// whether an error was encountered writing the hex dump to the wrapped writer.  Once a write to
+// the wrapped writer fails, nothing more is written to it, and every later Write and Close
+// returns that error.
 func (d *Dumper) Write(p []byte) (n int, err error) {
+	return d.WriteMasked(p, nil)
+}
+
+// WriteMasked is like Write, but also takes the mask for each byte in p, as returned by
+// Decoder.ReadMasked.  Bits that aren't set in the mask are written as wildcards, like "??".
+// If mask is nil, every byte is known.  Otherwise it must be at least as long as p.
+func (d *Dumper) WriteMasked(p, mask []byte) (n int, err error) {
+	if mask != nil && len(mask) < len(p) {
+		return 0, errors.New("mask shorter than p")
+	}
+	if d.w.err != nil {
+		return 0, d.w.err
+	}
 	//defer gotrace.In("Write(%d bytes)", len(p))()
 	// Check that we've encountered a Seek, and if so, finish up any previous segment before
 	// moving on.
 	d.honorSeekIfNeeded()
+	d.refreshAnnotations(d.end() + int64(len(p)))
 
 	// Mark that a Write occurred to ensure *something* gets written if we encounter a Close
 	// or a Seek without data being written otherwise.  This enables empty writes to nevertheless
 	// trigger writing out labels attached to the offset.
 	d.writePending = true
 
+	for n < len(p) {
+		k, r := d.redacted(len(p) - n)
+		if r != nil {
+			d.writeRedacted(p[n:n+k], r)
+		} else if mask != nil {
+			d.write(p[n:n+k], mask[n:n+k])
+		} else {
+			d.write(p[n:n+k], nil)
+		}
+		n += k
+	}
+	// A later Write continues from here unless Seek says otherwise.
+	d.nextOff = d.end()
+	return n, d.w.err
+}
+
+// write writes all of p, with the masks in mask if it isn't nil.
+func (d *Dumper) write(p, mask []byte) {
+	for n := 0; n < len(p); {
+		if d.fillMin > 0 && mask == nil {
+			if n += d.scanRun(p[n:]); n == len(p) {
+				break
+			}
+		}
+		if d.run > 0 {
+			d.flushRun()
+		}
+		var m []byte
+		if mask != nil {
+			m = mask[n:]
+		}
+		n += d.writeData(p[n:], m)
+	}
+}
+
+// end returns the offset following all of the data written so far.
+func (d *Dumper) end() int64 {
+	return d.data.ofs + int64(d.data.have) + d.run
+}
+
+// writeData writes lines from p, with the masks in mask if it isn't nil, returning the number
+// of bytes consumed.  If fills are enabled, this stops at the end of a line, so that a run can
+// be looked for.
+func (d *Dumper) writeData(p, mask []byte) (n int) {
 	for n < len(p) {
 		// Aim to complete a full line of 0x10 bytes, less if the offset starts mid-way into the
 		// line, and less if we have to break the line in order to get a label written.
 		want := 0x10 - int(d.data.ofs%0x10)
-		if d.labelIter.Ofs > 0 && d.data.ofs+int64(want) > d.labelIter.Ofs {
-			want = int(d.labelIter.Ofs - d.data.ofs)
+		if next := d.nextAnnotation(); next >= 0 && d.data.ofs+int64(want) > next {
+			want = int(next - d.data.ofs)
+			if want < d.data.have {
+				want = d.data.have // already buffered bytes beyond a label that arrived late
+			}
 		}
 
 		// If we're short, try to get more from p.
-		n += d.data.fill(p[n:], want)
+		var m []byte
+		if mask != nil {
+			m = mask[n:]
+		}
+		n += d.data.fill(p[n:], m, want)
 		//gotrace.Log("have so far %q", p[:n])
 
 		// We should always have <= want bytes at this point.  If we didn't get enough bytes from
@@ -132,29 +303,163 @@ func (d *Dumper) Write(p []byte) (n int, err error) {
 				d.writeLine(false)
 			}
 			d.wroteAnything = true // used by honorSeekIfNeeded to emit a blank line
+			if d.fillMin > 0 && d.data.ofs%0x10 == 0 {
+				break
+			}
 		}
 	}
 	return
 }
 
+// scanRun extends the current run of identical bytes with those at the start of p, or
+// starts a new one if we're at the start of a line.  Returns the number of bytes consumed.
+// The run ends before the next label or comment.
+func (d *Dumper) scanRun(p []byte) (n int) {
+	if d.run == 0 {
+		if d.data.have > 0 || d.data.ofs%0x10 != 0 {
+			return 0
+		}
+		d.runByte = p[0]
+	}
+	limit := int64(len(p))
+	if next := d.annotationAfter(d.data.ofs); next >= 0 && next-d.end() < limit {
+		limit = next - d.end()
+	}
+	for int64(n) < limit && p[n] == d.runByte {
+		n++
+	}
+	d.run += int64(n)
+	return n
+}
+
+// annotationAfter returns the offset of the first label or comment after ofs, or <0 if there
+// are none.
+func (d *Dumper) annotationAfter(ofs int64) int64 {
+	next := d.labelIter.after(ofs)
+	if c := d.commentIter.after(ofs); c >= 0 && (next < 0 || c < next) {
+		next = c
+	}
+	return next
+}
+
+// flushRun ends the current run of identical bytes, writing as much of it as possible as a
+// fill directive and the rest as ordinary lines.
+func (d *Dumper) flushRun() {
+	run := d.run
+	d.run = 0
+	if fill := run &^ 0xF; fill >= int64(d.fillMin) {
+		d.writeLabelsIfNeeded()
+		sb := d.appendOffsetColumns(d.line[:0], d.data.ofs)
+		sb = append(sb, ".fill 0x"...)
+		sb = appendHex(sb, fill, 1)
+		sb = append(sb, ' ', hexTab[d.runByte][0], hexTab[d.runByte][1], '\n')
+		d.line = sb[:0]
+		d.w.Write(sb)
+		d.data.set(d.data.ofs + fill)
+		d.writePending = false
+		d.wroteAnything = true
+		run -= fill
+	}
+	var buf [0x10]byte
+	for i := range buf {
+		buf[i] = d.runByte
+	}
+	for run > 0 {
+		k := int64(len(buf))
+		if run < k {
+			k = run
+		}
+		run -= int64(d.writeData(buf[:k], nil))
+	}
+}
+
 // wrapUp is called when we need to honor a seek, or when Close is called, to finish any
 // pending lines.
 func (d *Dumper) wrapUp() {
+	d.refreshAnnotations(d.end())
+	if d.run > 0 {
+		d.flushRun()
+	}
 	if d.writePending || d.data.have > 0 {
 		d.writeLabelsIfNeeded()
 		d.writeLine(true) // force writing an offset because there will not be a following line with one
 	}
 }
 
+// lineCap is enough room for the longest line writeLine can produce: a 16-digit offset, 16 hex
+// values with padding, and 16 printable characters of up to 2 bytes each.
+const lineCap = 16 + 2 + 16*3 + 1 + 16 + 2 + 16*2 + 2
+
+// hexTab holds the two uppercase hex digits for each byte value.
+var hexTab [256][2]byte
+
+// printTab holds the representation of each byte value in the printable column.
+var printTab [256]string
+
+func init() {
+	const digits = "0123456789ABCDEF"
+	for i := range hexTab {
+		hexTab[i] = [2]byte{digits[i>>4], digits[i&0xF]}
+		if strconv.IsPrint(rune(i)) && i != '|' {
+			printTab[i] = string(rune(i))
+		} else {
+			printTab[i] = "."
+		}
+	}
+}
+
+// appendOffset appends ofs to buf as at least 8 uppercase hex digits, like "%08X".
+func appendOffset(buf []byte, ofs int64) []byte {
+	return appendHex(buf, ofs, 8)
+}
+
+// appendHex appends v to buf as at least min uppercase hex digits.
+func appendHex(buf []byte, v int64, min int) []byte {
+	n := min
+	for v>>(uint(n)*4) != 0 {
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, hexTab[byte(v>>(uint(i)*4))&0xF][1])
+	}
+	return buf
+}
+
+// appendOffsetColumns appends ofs to buf as the start of a line, followed by its offset relative
+// to the labels if SetRelative is in effect.
+func (d *Dumper) appendOffsetColumns(buf []byte, ofs int64) []byte {
+	buf = appendOffset(buf, ofs)
+	buf = append(buf, ' ', ' ')
+	if d.relative {
+		start := len(buf)
+		buf = append(buf, '<')
+		buf = append(buf, d.labels.Symbolize(ofs)...)
+		buf = append(buf, '>')
+		if len(buf)-start > d.relativeWidth {
+			d.relativeWidth = len(buf) - start
+		}
+		buf = appendSpaces(buf, d.relativeWidth-(len(buf)-start)+2)
+	}
+	return buf
+}
+
+// appendSpaces appends n spaces to buf.
+func appendSpaces(buf []byte, n int) []byte {
+	for ; n > 0; n-- {
+		buf = append(buf, ' ')
+	}
+	return buf
+}
+
 // writeLine emits one line of data, draining d.data in the process.  If the offset is not
 // a multiple of 0x10 (and forceOffset is false), the offset will be skipped and should be
 // inferred from the offset of the next line.
 func (d *Dumper) writeLine(forceOffset bool) (err error) {
-	ofs, buf := d.data.take()
+	ofs, buf, mask := d.data.take()
 	skipLeft := int(ofs % 0x10)
 	skipRight := 0x10 - (len(buf) + skipLeft)
 
-	var sb bytes.Buffer // accumulate the line here and we'll Write it all at once
+	sb := d.line[:0] // accumulate the line here and we'll Write it all at once
 
 	// Normally if ofs isn't a multiple of 0x10 we skip writing the offset, because a following line
 	// should give us an offset instead.  But after a Seek or a Close, we won't get that chance and
@@ -170,47 +475,54 @@ func (d *Dumper) writeLine(forceOffset bool) (err error) {
 
 	// Part 1: Offset
 	if skipLeft == 0 || forceOffset {
-		fmt.Fprintf(&sb, "%08X  ", ofs)
+		sb = d.appendOffsetColumns(sb, ofs)
 	} else {
-		fmt.Fprintf(&sb, "%8s  ", "")
+		sb = appendSpaces(sb, 10)
+		if d.relative {
+			sb = appendSpaces(sb, d.relativeWidth+2)
+		}
 	}
 
-	// Part 2: Hex values
-	for i := 0; i < skipLeft; i++ {
-		fmt.Fprint(&sb, "   ")
-		if i == 7 {
-			fmt.Fprint(&sb, " ") // extra space mid-way through
-		}
+	// Part 2: Hex values, with an extra space mid-way through
+	sb = appendSpaces(sb, skipLeft*3)
+	if skipLeft > 7 {
+		sb = append(sb, ' ')
 	}
 	for i, b := range buf {
-		fmt.Fprintf(&sb, "%02X ", b)
+		h := hexTab[b]
+		if mask != nil && mask[i] != 0xFF {
+			if mask[i]&0xF0 != 0xF0 {
+				h[0] = '?'
+			}
+			if mask[i]&0x0F != 0x0F {
+				h[1] = '?'
+			}
+		}
+		sb = append(sb, h[0], h[1], ' ')
 		if i+skipLeft == 7 {
-			fmt.Fprint(&sb, " ")
+			sb = append(sb, ' ')
 		}
 	}
-	for i := 0; i < skipRight; i++ {
-		fmt.Fprint(&sb, "   ")
-		if i+len(buf)+skipLeft == 7 {
-			fmt.Fprint(&sb, " ")
-		}
+	sb = appendSpaces(sb, skipRight*3)
+	if skipLeft+len(buf) <= 7 && skipRight > 0 {
+		sb = append(sb, ' ')
 	}
 
 	// Part 3: Printable characters
-	for i := 0; i < skipLeft; i++ {
-		fmt.Fprint(&sb, " ")
-	}
-	fmt.Fprint(&sb, " |")
-	for _, b := range buf {
-		if strconv.IsPrint(rune(b)) && b != '|' {
-			fmt.Fprintf(&sb, "%c", b)
-		} else {
-			fmt.Fprint(&sb, ".")
+	sb = appendSpaces(sb, skipLeft+1)
+	sb = append(sb, '|')
+	for i, b := range buf {
+		if mask != nil && mask[i] != 0xFF {
+			sb = append(sb, '?')
+			continue
 		}
+		sb = append(sb, printTab[b]...)
 	}
-	fmt.Fprintln(&sb, "|")
+	sb = append(sb, '|', '\n')
 
-	// Write the completed line to d.w.
-	_, err = d.w.Write(sb.Bytes())
+	// Write the completed line to d.w, keeping any growth of the buffer for next time.
+	d.line = sb[:0]
+	_, err = d.w.Write(sb)
 	return
 }
 
@@ -223,7 +535,7 @@ func (d *Dumper) Seek(ofs int64, whence int) (n int64, err error) {
 	switch whence {
 	case io.SeekStart:
 	case io.SeekCurrent, io.SeekEnd:
-		ofs = ofs + d.data.ofs + int64(d.data.have)
+		ofs = ofs + d.end()
 	default:
 		return d.data.ofs, errors.New("invalid whence")
 	}
@@ -237,15 +549,35 @@ func (d *Dumper) Seek(ofs int64, whence int) (n int64, err error) {
 	return d.nextOff, nil
 }
 
-// Close finishes writing any partial hex dump line.  This does not close the underlying
-// writer.
+// Close finishes writing any partial hex dump line, returning the first error encountered
+// writing to the wrapped writer, if any.  This does not close the underlying writer.
 func (d *Dumper) Close() (err error) {
 	d.wrapUp()
 	if d.wroteAnything {
 		d.writeLabelsIfNeeded() // any lingering labels pointing to the end of the data
 	}
+	d.writeRedactionNotes(-1)
 	d.closed = true
-	return nil
+	return d.w.err
+}
+
+// errWriter passes writes through to w until one fails, after which it keeps that error and
+// writes nothing more.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (n int, err error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err = ew.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	ew.err = err
+	return n, err
 }
 
 // Dump returns a hex dump of data, with optional labels.
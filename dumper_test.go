@@ -2,9 +2,12 @@ package lhex_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/dnesting/lhex"
@@ -109,6 +112,17 @@ func TestDumper(t *testing.T) {
 	verify(t, "early end multi-write", buf, `
 00000000  00 01 02 03 04 05 06 07  08 09                    |..........|`)
 
+	// Multiple writes spanning several lines
+	buf.Reset()
+	w = lhex.NewDumper(&buf, nil)
+	w.Write(data[:0x18])
+	w.Write(data[0x18:0x30])
+	w.Close()
+	verify(t, "multi-line multi-write", buf, `
+00000000  00 01 02 03 04 05 06 07  08 09 0A 0B 0C 0D 0E 0F  |................|
+00000010  10 11 12 13 14 15 16 17  18 19 1A 1B 1C 1D 1E 1F  |................|
+00000020  20 21 22 23 24 25 26 27  28 29 2A 2B 2C 2D 2E 2F  | !"#$%&'()*+,-./|`)
+
 	// Incomplete line sandwiched between regular ones
 	buf.Reset()
 	w = lhex.NewDumper(&buf, nil)
@@ -316,3 +330,227 @@ func ExampleDump() {
 	// 00000020  51 52 53 54 55 56 57 58  59 5A 5B 5C 5D 5E 5F 60  |QRSTUVWXYZ[\]^_`|
 	// :end
 }
+
+func TestDumperComments(t *testing.T) {
+	data := make([]byte, 0x20)
+	var comments lhex.Comments
+	comments.Add(0, "start")
+	comments.Add(0x14, "")
+	comments.Add(0x14, "middle")
+	comments.Add(0x20, "end")
+
+	var buf bytes.Buffer
+	w := lhex.NewDumper(&buf, lhex.NewLabels(map[string]int64{"mid": 0x14}))
+	w.SetComments(&comments)
+	w.Write(data)
+	w.Close()
+	verify(t, "comments", buf, `
+# start
+00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+00000010  00 00 00 00                                       |....|
+#
+# middle
+:mid
+                      00 00 00 00  00 00 00 00 00 00 00 00      |............|
+# end
+00000020                                                    ||`)
+}
+
+func benchmarkDumper(b *testing.B, size, chunk int, gap int64) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := lhex.NewDumper(ioutil.Discard, nil)
+		for ofs := 0; ofs < size; ofs += chunk {
+			if gap > 0 {
+				d.Seek(gap, io.SeekCurrent)
+			}
+			d.Write(data[ofs : ofs+chunk])
+		}
+		d.Close()
+	}
+}
+
+func BenchmarkDumperContiguous(b *testing.B) { benchmarkDumper(b, 1<<24, 1<<16, 0) }
+func BenchmarkDumperSparse(b *testing.B)     { benchmarkDumper(b, 40*1<<16, 40, 0x1000) }
+
+func TestDumperRelative(t *testing.T) {
+	data := make([]byte, 0x30)
+	labels := lhex.NewLabels(map[string]int64{"header": 0x10, "body": 0x24})
+
+	var buf bytes.Buffer
+	w := lhex.NewDumper(&buf, labels)
+	w.SetRelative(true)
+	w.Write(data)
+	w.Close()
+	verify(t, "relative", buf, `
+00000000  <0x0>                00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+:header
+00000010  <header>             00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+00000020  <header+0x10>        00 00 00 00                                       |....|
+:body
+                                           00 00 00 00  00 00 00 00 00 00 00 00      |............|
+00000030  <body+0xC>                                                             ||`)
+
+	// Distances past 0xFFFF keep the columns aligned.
+	var far bytes.Buffer
+	w = lhex.NewDumper(&far, lhex.NewLabels(map[string]int64{"start": 0}))
+	w.SetRelative(true)
+	w.Seek(0xFFF0, io.SeekStart)
+	w.Write(make([]byte, 0x20))
+	w.Close()
+	verify(t, "relative far", far, `
+0000FFF0  <start+0xFFF0>      00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+00010000  <start+0x10000>     00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|`)
+
+	// The relative column should be ignored when decoding.
+	dec := lhex.NewDecoder(&buf)
+	got, err := lhex.ReadSegments(dec)
+	if err != nil || len(got) != 1 || len(got[0].Data) != 0x30 {
+		t.Errorf("relative dump should decode to the original data, got %v, %v", got, err)
+	}
+}
+
+func TestDumperFill(t *testing.T) {
+	data := append(append([]byte("hello"), bytes.Repeat([]byte{0xFF}, 0x1000)...), 1, 2, 3)
+	var buf bytes.Buffer
+	w := lhex.NewDumper(&buf, lhex.NewLabels(map[string]int64{"mid": 0x800}))
+	w.SetFill(0x40)
+	w.Seek(0x100, io.SeekStart)
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		w.Write(data[i:end])
+	}
+	w.Close()
+	want := `
+00000100  68 65 6C 6C 6F FF FF FF  FF FF FF FF FF FF FF FF  |helloÿÿÿÿÿÿÿÿÿÿÿ|
+00000110  .fill 0x6F0 FF
+:mid
+00000800  .fill 0x900 FF
+00001100  FF FF FF FF FF 01 02 03                           |ÿÿÿÿÿ...|`
+	verify(t, "fill", buf, want)
+
+	f, err := lhex.Decode(strings.NewReader(want))
+	if err != nil {
+		t.Fatalf("decoding fill output failed: %v", err)
+	}
+	if len(f.Segments) != 1 || f.Segments[0].Offset != 0x100 || !bytes.Equal(f.Segments[0].Data, data) {
+		t.Errorf("fill output should decode to the original data, got %v", f.Segments)
+	}
+
+	// Fill lines get a relative column like data lines.
+	buf.Reset()
+	w = lhex.NewDumper(&buf, lhex.NewLabels(map[string]int64{"mid": 0x800}))
+	w.SetFill(0x40)
+	w.SetRelative(true)
+	w.Seek(0x800, io.SeekStart)
+	w.Write(bytes.Repeat([]byte{0xFF}, 0x100))
+	w.Write([]byte{1})
+	w.Close()
+	verify(t, "relative fill", buf, `
+:mid
+00000800  <mid>             .fill 0x100 FF
+00000900  <mid+0x100>       01                                                |.|`)
+}
+
+func TestDumperWildcards(t *testing.T) {
+	input := `00000000  01 ?? 4? ?F 41                                    |.???A|
+`
+	f, err := lhex.Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	verify(t, "wildcards", buf, input)
+
+	buf.Reset()
+	w := lhex.NewDumper(&buf, nil)
+	if _, err := w.WriteMasked([]byte{1, 2}, []byte{0xFF}); err == nil {
+		t.Errorf("WriteMasked should reject a short mask")
+	}
+}
+
+func TestDumperRedact(t *testing.T) {
+	var buf bytes.Buffer
+	w := lhex.NewDumper(&buf, lhex.NewLabels(map[string]int64{"key": 0x14, "after": 0x24}))
+	w.SetRedactionKey([]byte("key"))
+	w.Redact(0x14, 0x20)
+	w.Redact(0x1C, 0x24)
+	w.Write([]byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGH"))
+	w.Close()
+	verify(t, "redact", buf, `
+00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|
+00000010  67 68 69 6A                                       |ghij|
+:key
+                      ?? ?? ?? ??  ?? ?? ?? ?? ?? ?? ?? ??      |????????????|
+00000020  ?? ?? ?? ??                                       |????|
+# redacted 0x10 bytes at 00000014, hmac-sha256 d3e242047aa96519650966524636f1d7bbdf44abbe41d66811f8f512ea46c3f1
+:after
+00000024  41 42 43 44 45 46 47 48                           |ABCDEFGH|`)
+
+	// Only part of a region is written before a Seek.
+	buf.Reset()
+	w = lhex.NewDumper(&buf, nil)
+	w.Redact(0x4, 0x100)
+	w.Write([]byte("abcdefgh"))
+	w.Seek(0x80, io.SeekStart)
+	w.Write([]byte("efgh"))
+	w.Seek(0x200, io.SeekStart)
+	w.Write([]byte("z"))
+	w.Close()
+	verify(t, "partial redact", buf, `
+00000000  61 62 63 64 ?? ?? ?? ??                           |abcd????|
+# redacted 0x4 bytes at 00000004
+00000080  ?? ?? ?? ??                                       |????|
+# redacted 0x4 bytes at 00000080
+00000200  7A                                                |z|`)
+}
+
+// failWriter accepts n bytes and fails every write after that.
+type failWriter struct {
+	n int
+}
+
+var errFail = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFail
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestDumperWriteError(t *testing.T) {
+	w := lhex.NewDumper(&failWriter{n: 100}, nil)
+	data := bytes.Repeat([]byte("x"), 0x40)
+	if _, err := w.Write(data); err != errFail {
+		t.Errorf("Write should fail with %v, got %v", errFail, err)
+	}
+	if n, err := w.Write(data); n != 0 || err != errFail {
+		t.Errorf("Write after a failure should return 0, %v, got %d, %v", errFail, n, err)
+	}
+	if err := w.Close(); err != errFail {
+		t.Errorf("Close should return %v, got %v", errFail, err)
+	}
+
+	// A failure writing the last, partial line is only seen by Close.
+	w = lhex.NewDumper(&failWriter{}, nil)
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Errorf("Write of a partial line failed: %v", err)
+	}
+	if err := w.Close(); err != errFail {
+		t.Errorf("Close should return %v, got %v", errFail, err)
+	}
+}
@@ -0,0 +1,138 @@
+package lhex
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// intType describes how an integer appearing in a data line, like u32le(foo), is encoded.
+type intType struct {
+	name      string
+	size      int
+	bigEndian bool
+	signed    bool
+}
+
+// intTypes lists the integer encodings usable in data lines.
+var intTypes = []intType{
+	{"u8", 1, false, false},
+	{"i8", 1, false, true},
+	{"u16le", 2, false, false},
+	{"u16be", 2, true, false},
+	{"i16le", 2, false, true},
+	{"i16be", 2, true, true},
+	{"u32le", 4, false, false},
+	{"u32be", 4, true, false},
+	{"i32le", 4, false, true},
+	{"i32be", 4, true, true},
+	{"u64le", 8, false, false},
+	{"u64be", 8, true, false},
+	{"i64le", 8, false, true},
+	{"i64be", 8, true, true},
+}
+
+// parseNumber parses s as a number in an expression or directive, which is hex if it starts with
+// "0x" and decimal otherwise, so that a leading zero doesn't make it octal as in Go.
+func parseNumber(s string) (int64, error) {
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return strconv.ParseInt(s[2:], 16, 64)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// lookupIntType returns the intType called name, if any.
+func lookupIntType(name []byte) (intType, bool) {
+	for _, t := range intTypes {
+		if t.name == string(name) {
+			return t, true
+		}
+	}
+	return intType{}, false
+}
+
+// put encodes v into buf, which must be t.size bytes long.  Returns an error if v doesn't
+// fit.
+func (t intType) put(buf []byte, v int64) error {
+	if t.size < 8 {
+		bits := uint(t.size * 8)
+		lo, hi := int64(0), int64(1)<<bits-1
+		if t.signed {
+			lo, hi = -1<<(bits-1), 1<<(bits-1)-1
+		}
+		if v < lo || v > hi {
+			return fmt.Errorf("value %d out of range for %s", v, t.name)
+		}
+	} else if !t.signed && v < 0 {
+		return fmt.Errorf("value %d out of range for %s", v, t.name)
+	}
+	for i := 0; i < t.size; i++ {
+		j := i
+		if t.bigEndian {
+			j = t.size - 1 - i
+		}
+		buf[j] = byte(v >> (8 * uint(i)))
+	}
+	return nil
+}
+
+// term is a single label or number in an expression, to be added or subtracted.
+type term struct {
+	neg   bool
+	label string
+	num   int64
+}
+
+// expr is a sum of terms, like "end - start" or "foo+4".
+type expr []term
+
+func (e expr) String() string {
+	var sb strings.Builder
+	for i, t := range e {
+		switch {
+		case i > 0 && t.neg:
+			sb.WriteString(" - ")
+		case i > 0:
+			sb.WriteString("+")
+		case t.neg:
+			sb.WriteString("-")
+		}
+		if t.label != "" {
+			sb.WriteString(t.label)
+		} else {
+			sb.WriteString(strconv.FormatInt(t.num, 10))
+		}
+	}
+	return sb.String()
+}
+
+// errUndefined is returned by eval when a label in the expression isn't defined yet.
+var errUndefined = errors.New("undefined label")
+
+// eval computes the value of e using the offsets in labels.  Returns an error wrapping
+// errUndefined if any label is not defined.
+func (e expr) eval(labels *Labels) (v int64, err error) {
+	for _, t := range e {
+		n := t.num
+		if t.label != "" {
+			var ok bool
+			if n, ok = labels.Get(t.label); !ok {
+				return 0, fmt.Errorf("%w %q", errUndefined, t.label)
+			}
+		}
+		if t.neg {
+			n = -n
+		}
+		v += n
+	}
+	return v, nil
+}
+
+// ref is a reference to an integer expression found in a data line, occupying t.size bytes
+// starting at pos in the line's data.
+type ref struct {
+	pos int
+	typ intType
+	x   expr
+}
@@ -0,0 +1,148 @@
+package lhex
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/dnesting/sparse"
+)
+
+// Segment is a contiguous run of data starting at Offset.
+type Segment struct {
+	Offset int64
+	Data   []byte
+
+	// Mask, if not nil, holds the known bits of each byte in Data.  Bits that are 0 in Mask
+	// are wildcards, and 0 in Data.
+	Mask []byte
+}
+
+// End returns the offset immediately following the segment's data.
+func (s Segment) End() int64 { return s.Offset + int64(len(s.Data)) }
+
+// File holds the complete contents of a hex dump in memory, as a sequence of
+// segments ordered by offset, along with any labels and comments.
+type File struct {
+	Segments []Segment
+	Labels   *Labels
+	Comments *Comments
+}
+
+// Decode reads the hexdump input from r in its entirety and returns its contents.
+func Decode(r io.Reader) (*File, error) {
+	dec := NewDecoder(r)
+	segs, err := ReadSegments(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Segments: segs, Labels: dec.Labels(), Comments: dec.Comments()}, nil
+}
+
+// NewFile returns a File containing data as a single segment at offset.
+func NewFile(data []byte, offset int64) *File {
+	return &File{Segments: []Segment{{Offset: offset, Data: data}}}
+}
+
+// maskedReader is implemented by readers like Decoder that can describe wildcard bytes.
+type maskedReader interface {
+	ReadMasked(p, mask []byte) (n int, err error)
+}
+
+// ReadSegments reads r in its entirety and returns each of its non-empty runs
+// of contiguous data as a Segment.  If r has a ReadMasked method, like Decoder,
+// segments containing wildcard bytes will have a Mask.
+func ReadSegments(r sparse.Reader) (segs []Segment, err error) {
+	var ofs int64
+	for {
+		var data, mask []byte
+		if mr, ok := r.(maskedReader); ok {
+			data, mask, err = readAllMasked(mr)
+		} else {
+			data, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if n := len(segs); n > 0 && segs[n-1].End() == ofs {
+				last := &segs[n-1]
+				last.Data, last.Mask = appendMasked(last.Data, last.Mask, data, mask)
+			} else {
+				segs = append(segs, Segment{Offset: ofs, Data: data, Mask: mask})
+			}
+		}
+		ofs += int64(len(data))
+
+		var skip int64
+		if skip, err = r.Next(); err == io.EOF {
+			return segs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ofs += skip
+	}
+}
+
+// readAllMasked is like ioutil.ReadAll for a maskedReader.  The returned mask is nil if all of
+// the data is known.
+func readAllMasked(r maskedReader) (data, mask []byte, err error) {
+	var buf, bufMask [4096]byte
+	var masked bool
+	for {
+		var n int
+		n, err = r.ReadMasked(buf[:], bufMask[:])
+		for _, m := range bufMask[:n] {
+			masked = masked || m != 0xFF
+		}
+		data, mask = append(data, buf[:n]...), append(mask, bufMask[:n]...)
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			break
+		}
+	}
+	if !masked {
+		mask = nil
+	}
+	return
+}
+
+// find returns the index of the segment containing ofs, or -1 if none does.
+func (f *File) find(ofs int64) int {
+	i := sort.Search(len(f.Segments), func(i int) bool { return f.Segments[i].End() > ofs })
+	if i < len(f.Segments) && f.Segments[i].Offset <= ofs {
+		return i
+	}
+	return -1
+}
+
+// WriteTo writes the contents of f to w as a hex dump.
+func (f *File) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countWriter{w: w}
+	dmp := NewDumper(cw, f.Labels)
+	dmp.SetComments(f.Comments)
+	for _, s := range f.Segments {
+		if _, err = dmp.Seek(s.Offset, io.SeekStart); err != nil {
+			return cw.n, err
+		}
+		if _, err = dmp.WriteMasked(s.Data, s.Mask); err != nil {
+			return cw.n, err
+		}
+	}
+	err = dmp.Close()
+	return cw.n, err
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
@@ -0,0 +1,85 @@
+package lhex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// summaryBytes is the most bytes of data included in the summary of Bytes.
+const summaryBytes = 16
+
+// Bytes wraps data so that printing it with the fmt package, or logging it with log/slog,
+// produces a hex dump.  The %v and %s verbs give a summary on a single line, like
+//
+//	12 bytes: 48 65 6C 6C 6F 2C 20 77 6F 72 6C 64  |Hello, world|
+//
+// with only the first 16 bytes shown.  The %+v verb gives a complete dump, with labels,
+// spanning as many lines as it takes.  Other verbs format Data as they would a []byte.
+type Bytes struct {
+	Data   []byte
+	Offset int64   // offset of Data's first byte in the dump
+	Labels *Labels // labels for the complete dump, or nil
+}
+
+// Format implements fmt.Formatter.
+func (b Bytes) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprint(f, Dump(b.Data, b.Offset, b.Labels))
+	case verb == 'v' || verb == 's':
+		fmt.Fprint(f, b.summary())
+	default:
+		fmt.Fprintf(f, formatString(f, verb), b.Data)
+	}
+}
+
+// String returns the summary of b, as printed by %v.
+func (b Bytes) String() string {
+	return b.summary()
+}
+
+// summary returns a single line describing b.Data.
+func (b Bytes) summary() string {
+	data := b.Data
+	if len(data) > summaryBytes {
+		data = data[:summaryBytes]
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d bytes", len(b.Data))
+	if len(data) == 0 {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, ": % X", data)
+	if len(data) < len(b.Data) {
+		sb.WriteString(" ...")
+	}
+	sb.WriteString("  |")
+	for _, c := range data {
+		if c < 32 || c > 126 {
+			c = '.'
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('|')
+	return sb.String()
+}
+
+// formatString rebuilds the directive that led to a call to Format, with its flags, width and
+// precision.
+func formatString(f fmt.State, verb rune) string {
+	buf := []byte{'%'}
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			buf = append(buf, byte(flag))
+		}
+	}
+	if w, ok := f.Width(); ok {
+		buf = strconv.AppendInt(buf, int64(w), 10)
+	}
+	if p, ok := f.Precision(); ok {
+		buf = append(buf, '.')
+		buf = strconv.AppendInt(buf, int64(p), 10)
+	}
+	return string(append(buf, string(verb)...))
+}
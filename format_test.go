@@ -0,0 +1,34 @@
+package lhex_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestBytesFormat(t *testing.T) {
+	labels := lhex.NewLabels(map[string]int64{"name": 7})
+	b := lhex.Bytes{Data: []byte("Hello, world"), Labels: labels}
+	long := lhex.Bytes{Data: make([]byte, 20)}
+	for _, test := range []struct {
+		format   string
+		arg      interface{}
+		expected string
+	}{
+		{"%v", b, "12 bytes: 48 65 6C 6C 6F 2C 20 77 6F 72 6C 64  |Hello, world|"},
+		{"%s", long, "20 bytes: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 ...  |................|"},
+		{"%v", lhex.Bytes{}, "0 bytes"},
+		{"%+v", b, `00000000  48 65 6C 6C 6F 2C 20                              |Hello, |
+:name
+00000007  77 6F 72 6C 64                                    |world|
+`},
+		{"%x", b, "48656c6c6f2c20776f726c64"},
+		{"% X", b, "48 65 6C 6C 6F 2C 20 77 6F 72 6C 64"},
+		{"%q", b, `"Hello, world"`},
+	} {
+		if got := fmt.Sprintf(test.format, test.arg); got != test.expected {
+			t.Errorf("Sprintf(%q) = %q, want %q", test.format, got, test.expected)
+		}
+	}
+}
@@ -1,12 +1,22 @@
 package lhex
 
-import "sort"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
 
 // Labels provides a mapping from label name to offset.
+//
+// Labels is safe for concurrent use, so a Decoder may add labels to it while
+// a Dumper running in another goroutine reads them.  Use Snapshot to obtain a
+// copy that won't reflect later changes.
 type Labels struct {
+	mu   sync.RWMutex
 	lmap map[string]int64 // the actual label data
 
-	// cached derivatives
+	// cached derivatives.  The slices in offLabels are replaced rather than modified, so
+	// callers may hold on to them without holding mu.
 	offLabels map[int64][]string
 	offsets   []int64
 }
@@ -21,7 +31,12 @@ func NewLabels(lmap map[string]int64) *Labels {
 
 // Get retrieves the label offset for name.  If the label does not exist, ok
 // will be false.
-func (l Labels) Get(name string) (ofs int64, ok bool) {
+func (l *Labels) Get(name string) (ofs int64, ok bool) {
+	if l == nil {
+		return 0, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	ofs, ok = l.lmap[name]
 	return
 }
@@ -36,27 +51,56 @@ func (l *Labels) init() {
 
 // Set sets the label name to have the offset ofs.
 func (l *Labels) Set(name string, ofs int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.init()
 	if o, ok := l.lmap[name]; ok {
-		if o != ofs {
-			// modifying an existing assignment, just re-generate the derived fields
-			l.lmap[name] = ofs
-			l.Reset(l.lmap)
-		}
-	} else {
-		// new assignment
-		l.lmap[name] = ofs
-		if _, ok := l.offLabels[ofs]; !ok {
-			l.offsets = append(l.offsets, ofs)
-			l.sortOffsets()
+		if o == ofs {
+			return
 		}
-		l.offLabels[ofs] = append(l.offLabels[ofs], name)
-		sort.Strings(l.offLabels[ofs])
+		l.unindex(name, o)
+	}
+	l.lmap[name] = ofs
+	l.index(name, ofs)
+}
+
+// index adds name to the cached derivatives at ofs.
+func (l *Labels) index(name string, ofs int64) {
+	names, ok := l.offLabels[ofs]
+	if !ok {
+		i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] >= ofs })
+		l.offsets = append(l.offsets, 0)
+		copy(l.offsets[i+1:], l.offsets[i:])
+		l.offsets[i] = ofs
 	}
+	i := sort.SearchStrings(names, name)
+	updated := make([]string, 0, len(names)+1)
+	updated = append(append(append(updated, names[:i]...), name), names[i:]...)
+	l.offLabels[ofs] = updated
+}
+
+// unindex removes name from the cached derivatives at ofs.
+func (l *Labels) unindex(name string, ofs int64) {
+	names := l.offLabels[ofs]
+	if len(names) == 1 {
+		delete(l.offLabels, ofs)
+		i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] >= ofs })
+		l.offsets = append(l.offsets[:i], l.offsets[i+1:]...)
+		return
+	}
+	i := sort.SearchStrings(names, name)
+	updated := make([]string, 0, len(names)-1)
+	l.offLabels[ofs] = append(append(updated, names[:i]...), names[i+1:]...)
 }
 
 // Reset reset the Labels instance to use the labels from labels instead.
 func (l *Labels) Reset(labels map[string]int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reset(labels)
+}
+
+func (l *Labels) reset(labels map[string]int64) {
 	l.lmap = labels
 	l.offLabels = make(map[int64][]string)
 	l.offsets = nil
@@ -69,56 +113,259 @@ func (l *Labels) Reset(labels map[string]int64) {
 	for off := range l.offLabels {
 		l.offsets = append(l.offsets, off)
 	}
-	l.sortOffsets()
+	sort.Slice(l.offsets, func(a, b int) bool { return l.offsets[a] < l.offsets[b] })
 }
 
-// All returns all of the label to offset mappings.  This map is live and
-// may be modified during further decoding.  Caller changes to this map will
-// result in undefined behavior.
+// All returns a copy of all of the label to offset mappings.
 func (l *Labels) All() map[string]int64 {
-	return l.lmap
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	m := make(map[string]int64, len(l.lmap))
+	for name, ofs := range l.lmap {
+		m[name] = ofs
+	}
+	return m
 }
 
-func (l *Labels) sortOffsets() {
-	sort.Slice(l.offsets, func(a, b int) bool { return l.offsets[a] < l.offsets[b] })
+// Snapshot returns a copy of l that will not reflect future changes to l.
+func (l *Labels) Snapshot() *Labels {
+	var snap Labels
+	snap.reset(l.All())
+	return &snap
 }
 
-// iter creates an iterator on labels, starting at or after ofs.  Changes to labels
-// may not be reflected in the resulting LabelIter.
-func (l *Labels) iter(ofs int64) *labelIter {
-	var it labelIter
-	if l != nil {
-		it.offLabels = l.offLabels
-		it.iter = l.offsets
+// LabelName returns name with any characters that can't appear in a label name replaced by
+// '_', for use with names from other sources, like symbol tables.
+func LabelName(name string) string {
+	if name == "" {
+		return "_"
 	}
-	for it.Next() {
-		if it.Ofs >= ofs {
-			break
+	buf := []byte(name)
+	for i, c := range buf {
+		if !isLabel(c, i > 0) {
+			buf[i] = '_'
 		}
 	}
+	return string(buf)
+}
+
+// Label is a label name and its offset.
+type Label struct {
+	Name   string
+	Offset int64
+}
+
+// FirstAt returns the first offset at or after ofs having labels, along with the names of
+// those labels in sorted order.  If there are no such labels, ok will be false.
+func (l *Labels) FirstAt(ofs int64) (at int64, names []string, ok bool) {
+	if l == nil {
+		return -1, nil, false
+	}
+	return l.first(ofs)
+}
+
+// Range returns the labels with offsets in [start, end), ordered by offset and then by name.
+func (l *Labels) Range(start, end int64) (labels []Label) {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] >= start })
+	for ; i < len(l.offsets) && l.offsets[i] < end; i++ {
+		for _, name := range l.offLabels[l.offsets[i]] {
+			labels = append(labels, Label{name, l.offsets[i]})
+		}
+	}
+	return
+}
+
+// Delete removes the label name, returning false if it did not exist.
+func (l *Labels) Delete(name string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ofs, ok := l.lmap[name]
+	if ok {
+		delete(l.lmap, name)
+		l.unindex(name, ofs)
+	}
+	return ok
+}
+
+// Rename renames the label oldName to newName, keeping its offset.  Returns an error if
+// oldName does not exist or newName already does.
+func (l *Labels) Rename(oldName, newName string) error {
+	if l == nil {
+		return fmt.Errorf("label %q does not exist", oldName)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ofs, ok := l.lmap[oldName]
+	if !ok {
+		return fmt.Errorf("label %q does not exist", oldName)
+	}
+	if _, ok := l.lmap[newName]; ok {
+		return fmt.Errorf("label %q already exists", newName)
+	}
+	delete(l.lmap, oldName)
+	l.unindex(oldName, ofs)
+	l.lmap[newName] = ofs
+	l.index(newName, ofs)
+	return nil
+}
+
+// first returns the first offset at or after ofs having labels, along with those labels.
+func (l *Labels) first(ofs int64) (int64, []string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] >= ofs })
+	if i == len(l.offsets) {
+		return -1, nil, false
+	}
+	return l.offsets[i], l.offLabels[l.offsets[i]], true
+}
+
+func (l *Labels) upcoming(from, until int64, buf []offsetEntry) []offsetEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return appendUpcoming(l.offsets, l.offLabels, from, until, buf)
+}
+
+// Iter returns an iterator on labels in order of offset, positioned at the
+// first offset at or after ofs having labels.  Labels added while iterating
+// are visited if they lie beyond the iterator's current position.  Typical use
+// looks like:
+//
+//	for it := labels.Iter(0); it.Ofs >= 0; it.Next() {
+//		fmt.Println(it.Ofs, it.Labels)
+//	}
+func (l *Labels) Iter(ofs int64) *LabelIter {
+	it := l.iter(ofs)
 	return &it
 }
 
-// labelIter is an iterator on label offsets.
-type labelIter struct {
+// iter is like Iter but returns the iterator by value.
+func (l *Labels) iter(ofs int64) LabelIter {
+	if l == nil {
+		return newLabelIter(nil, ofs)
+	}
+	return newLabelIter(l, ofs)
+}
+
+// offsetIndex is implemented by types holding sorted lists of strings at offsets.
+type offsetIndex interface {
+	// first returns the first offset at or after ofs, along with its strings.
+	first(ofs int64) (int64, []string, bool)
+
+	// upcoming appends to buf the offsets in [from, until] along with their strings, followed
+	// by the first offset after until, if any.
+	upcoming(from, until int64, buf []offsetEntry) []offsetEntry
+}
+
+// offsetEntry holds the strings at an offset in an offsetIndex.
+type offsetEntry struct {
+	ofs  int64
+	strs []string
+}
+
+// appendUpcoming implements offsetIndex.upcoming for sorted offsets and the strings at each.
+func appendUpcoming(offsets []int64, strs map[int64][]string, from, until int64, buf []offsetEntry) []offsetEntry {
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= from })
+	for ; i < len(offsets); i++ {
+		buf = append(buf, offsetEntry{offsets[i], strs[offsets[i]]})
+		if offsets[i] > until {
+			break
+		}
+	}
+	return buf
+}
+
+// newLabelIter creates an iterator on idx, starting at or after ofs.
+func newLabelIter(idx offsetIndex, ofs int64) LabelIter {
+	it := LabelIter{idx: idx, from: ofs}
+	it.refresh()
+	return it
+}
+
+// LabelIter is an iterator on label offsets, created by Labels.Iter.  CommentIter and the
+// Dumper use it for comment offsets as well.
+type LabelIter struct {
 	// Ofs is the offset of the next label set.  If no more labels exist, this will be <0.
 	Ofs int64
-	// Labels contains the labels at Ofs.  If no more labels exist, this will be nil.
+	// Labels contains the labels at Ofs, sorted by name.  If no more labels exist, this will
+	// be nil.  Callers must not modify it.
 	Labels []string
 
-	offLabels map[int64][]string
-	iter      []int64
+	idx  offsetIndex // nil if there's nothing to iterate
+	from int64       // offsets before this have already been visited
+
+	// snap holds the offsets from Ofs on, as of the last call to sync, so they can be visited
+	// without consulting idx each time.
+	snap []offsetEntry
 }
 
 // Next advances to the next offset that has labels.  If no more labels exist,
 // returns false.
-func (it *labelIter) Next() (ok bool) {
-	if len(it.iter) > 0 {
-		it.Ofs = it.iter[0]
-		it.Labels = it.offLabels[it.Ofs]
-		ok = true
-		it.iter = it.iter[1:]
-	} else {
+func (it *LabelIter) Next() (ok bool) {
+	if it.Ofs >= 0 {
+		it.from = it.Ofs + 1
+	}
+	if len(it.snap) > 1 {
+		it.snap = it.snap[1:]
+		it.Ofs, it.Labels = it.snap[0].ofs, it.snap[0].strs
+		return true
+	}
+	it.snap = it.snap[:0]
+	return it.refresh()
+}
+
+// sync takes a snapshot of the offsets from the iterator's position up to until, along with
+// the first one after that, skipping any before min.  Until the iterator moves past the
+// snapshot, it reflects no further changes to the underlying labels.  Returns false if no more
+// labels exist.
+func (it *LabelIter) sync(min, until int64) bool {
+	if it.from < min {
+		it.from = min
+	}
+	if it.idx != nil {
+		it.snap = it.idx.upcoming(it.from, until, it.snap[:0])
+	}
+	if len(it.snap) == 0 {
+		it.Ofs, it.Labels = -1, nil
+		return false
+	}
+	it.Ofs, it.Labels = it.snap[0].ofs, it.snap[0].strs
+	return true
+}
+
+// after returns the first offset after ofs, which must not be before the iterator's position,
+// having labels, or <0 if there is none.
+func (it *LabelIter) after(ofs int64) int64 {
+	if len(it.snap) == 0 && it.idx != nil {
+		next, _, _ := it.idx.first(ofs + 1)
+		return next
+	}
+	for _, e := range it.snap {
+		if e.ofs > ofs {
+			return e.ofs
+		}
+	}
+	return -1
+}
+
+// refresh updates Ofs and Labels to reflect any changes made to the underlying labels since
+// they were last retrieved.  Returns false if no more labels exist.
+func (it *LabelIter) refresh() (ok bool) {
+	if it.idx != nil {
+		it.Ofs, it.Labels, ok = it.idx.first(it.from)
+	}
+	if !ok {
 		it.Ofs = -1
 		it.Labels = nil
 	}
@@ -0,0 +1,200 @@
+package lhex_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestLabelsSnapshot(t *testing.T) {
+	labels := lhex.NewLabels(map[string]int64{"a": 1, "b": 2})
+	snap := labels.Snapshot()
+	labels.Set("a", 3)
+	labels.Set("c", 4)
+	if ofs, ok := snap.Get("a"); !ok || ofs != 1 {
+		t.Errorf("snapshot should keep a=1, got %d, %v", ofs, ok)
+	}
+	if _, ok := snap.Get("c"); ok {
+		t.Errorf("snapshot should not see labels added later")
+	}
+	if ofs, _ := labels.Get("a"); ofs != 3 {
+		t.Errorf("original should have a=3, got %d", ofs)
+	}
+}
+
+func TestLabelsAll(t *testing.T) {
+	labels := lhex.NewLabels(map[string]int64{"a": 1})
+	all := labels.All()
+	all["b"] = 2
+	if _, ok := labels.Get("b"); ok {
+		t.Errorf("changing the map from All should not change the labels")
+	}
+
+	var none *lhex.Labels
+	if _, ok := none.Get("a"); ok || none.All() != nil {
+		t.Errorf("nil Labels should have no labels")
+	}
+	if none.Delete("a") || none.Rename("a", "b") == nil {
+		t.Errorf("nil Labels should have no labels to delete or rename")
+	}
+}
+
+// TestLabelsConcurrent exercises Labels from several goroutines at once, and is most useful
+// when run with -race.
+func TestLabelsConcurrent(t *testing.T) {
+	var labels lhex.Labels
+	var comments lhex.Comments
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				labels.Set(fmt.Sprintf("l%d_%d", g, i%50), int64(i))
+				comments.Add(int64(i), "comment")
+				labels.Get("l0_0")
+				labels.All()
+			}
+		}(g)
+	}
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				d := lhex.NewDumper(ioutil.Discard, &labels)
+				d.SetComments(&comments)
+				d.Write(make([]byte, 0x100))
+				d.Close()
+				labels.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestDecodeDumpPipeline decodes in one goroutine while dumping in another, sharing the
+// decoder's live labels and comments.
+func TestDecodeDumpPipeline(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "# line %d\n:l%d\n%08X  %02X\n", i, i, i, i)
+	}
+	dec := lhex.NewDecoder(strings.NewReader(input.String()))
+
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		p := make([]byte, 1)
+		for {
+			n, err := dec.Read(p)
+			if err != nil {
+				return
+			}
+			chunks <- append([]byte(nil), p[:n]...)
+		}
+	}()
+
+	var out bytes.Buffer
+	dmp := lhex.NewDumper(&out, dec.Labels())
+	dmp.SetComments(dec.Comments())
+	for c := range chunks {
+		dmp.Write(c)
+	}
+	dmp.Close()
+
+	if !strings.Contains(out.String(), "# line 99\n:l99\n") {
+		t.Errorf("dump should contain labels and comments decoded concurrently, got:\n%s", out.String())
+	}
+}
+
+func TestLabelsQueries(t *testing.T) {
+	labels := lhex.NewLabels(map[string]int64{
+		"a": 0x10,
+		"b": 0x20,
+		"c": 0x20,
+		"d": 0x30,
+	})
+
+	var got []string
+	for it := labels.Iter(0x11); it.Ofs >= 0; it.Next() {
+		got = append(got, fmt.Sprintf("%X:%s", it.Ofs, strings.Join(it.Labels, ",")))
+	}
+	if s := strings.Join(got, " "); s != "20:b,c 30:d" {
+		t.Errorf("Iter(0x11) should visit 20:b,c 30:d, got %s", s)
+	}
+
+	if r := labels.Range(0x10, 0x30); len(r) != 3 || r[0] != (lhex.Label{"a", 0x10}) || r[2] != (lhex.Label{"c", 0x20}) {
+		t.Errorf("Range(0x10, 0x30) should give a, b, c, got %v", r)
+	}
+	if at, names, ok := labels.FirstAt(0x21); !ok || at != 0x30 || names[0] != "d" {
+		t.Errorf("FirstAt(0x21) should give 0x30 [d], got 0x%X %v %v", at, names, ok)
+	}
+	if _, _, ok := labels.FirstAt(0x31); ok {
+		t.Errorf("FirstAt(0x31) should find nothing")
+	}
+
+	if !labels.Delete("b") || labels.Delete("b") {
+		t.Errorf("Delete should succeed once")
+	}
+	if err := labels.Rename("c", "a"); err == nil {
+		t.Errorf("Rename to an existing label should fail")
+	}
+	if err := labels.Rename("c", "e"); err != nil {
+		t.Errorf("Rename should succeed, got %v", err)
+	}
+	if r := labels.Range(0, 0x100); len(r) != 3 || r[1] != (lhex.Label{"e", 0x20}) {
+		t.Errorf("labels after Delete and Rename should be a, e, d, got %v", r)
+	}
+	labels.Delete("e")
+	if at, _, _ := labels.FirstAt(0x11); at != 0x30 {
+		t.Errorf("deleting the only label at 0x20 should remove the offset, got 0x%X", at)
+	}
+}
+
+func TestSymbolize(t *testing.T) {
+	labels := lhex.NewLabels(map[string]int64{
+		"foo":     0x10,
+		"bar":     0x20,
+		"bar-alt": 0x20,
+	})
+	for _, tc := range []struct {
+		ofs  int64
+		expr string
+	}{
+		{0x8, "0x8"},
+		{0x10, "foo"},
+		{0x12, "foo+0x2"},
+		{0x20, "bar"},
+		{0x123, "bar+0x103"},
+	} {
+		if got := labels.Symbolize(tc.ofs); got != tc.expr {
+			t.Errorf("Symbolize(0x%X) should give %q, got %q", tc.ofs, tc.expr, got)
+		}
+		if got, err := labels.Resolve(tc.expr); got != tc.ofs || err != nil {
+			t.Errorf("Resolve(%q) should give 0x%X, got 0x%X, %v", tc.expr, tc.ofs, got, err)
+		}
+	}
+
+	for expr, ofs := range map[string]int64{
+		"bar-alt":    0x20,
+		"bar-alt+1":  0x21,
+		"foo - 0x10": 0,
+		"16":         16,
+		"foo+010":    0x1A,
+	} {
+		if got, err := labels.Resolve(expr); got != ofs || err != nil {
+			t.Errorf("Resolve(%q) should give 0x%X, got 0x%X, %v", expr, ofs, got, err)
+		}
+	}
+	for _, expr := range []string{"baz", "baz+1", "foo+bar", ""} {
+		if _, err := labels.Resolve(expr); err == nil {
+			t.Errorf("Resolve(%q) should fail", expr)
+		}
+	}
+}
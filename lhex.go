@@ -37,5 +37,63 @@ The basic file format looks like this:
 
   # Offsets can be up to 63 bits long.
   7FFFFFFF00000000  77 78 79 7A 7B 7C 7D 7E  7F 80 81 82 83 84 85 86  |wxyz{.}~........|
+
+Literals and References
+
+Data lines may also contain double-quoted strings, using the same escapes as Go
+string literals, and typed integers.  Both are useful when authoring files by
+hand, and may be mixed with hex bytes.  A typed integer's value is an
+expression adding and subtracting labels and numbers, written with the
+integer's type: u8, u16le, u16be, u32le, u32be, u64le or u64be, or their signed
+counterparts starting with 'i'.  Labels may be defined later in the file; the
+Decoder fills in each value once the labels it needs are known, and fails if
+any never are.  Numbers are decimal, or hex if prefixed with "0x".  Since label
+names may contain '-', subtraction needs a space before the '-'.
+
+  # a pointer to body, followed by its length
+  00000000  u32le(body) u16le(end - body)
+  :body
+  00000100  "GET / HTTP/1.1\r\n" u16be(0x1234) 00
+  :end
+
+Large regions of repeated data can be described with a fill directive, giving
+the region's length and an optional pattern of hex bytes to repeat, which
+defaults to 00.  The Decoder generates the data as it's read, and a Dumper can
+be asked to write long runs of identical bytes this way with SetFill.
+
+  00001000  .fill 0x10000 FF
+
+Bytes that vary, like nonces or timestamps, can be written as wildcards, so
+that a file describes a template rather than one exact capture.  Either or
+both hex digits of a byte may be '?'.  Wildcard bits read as 0, and
+Decoder.ReadMasked reports which bits are known.
+
+  00000000  01 00 ?? ?? ?? ?? 4? 00                           |..?????.|
+
+A Decoder with SetKeepLiterals enabled records these lines as comments, so the
+"lhex fmt" command can rewrite them in plain hex while keeping the original.
+
+Transcripts
+
+A conversation between a client and a server can be recorded by marking which
+side sent each block of data.  A line holding just '>' starts a block sent by
+the client, and '<' one sent by the server, each optionally followed by a
+comment.  Each direction has offsets, labels and references of its own, and
+data without an offset follows what was last sent in the same direction.
+Decoder.ReadTranscript returns the messages in order, along with the data sent
+in each direction.  A Recorder writes transcripts like this of the traffic
+passing through a connection.
+
+Dumps in Other Formats
+
+Decoder.SetDialect reads hex dumps embedded in other output, like log files,
+skipping lines that aren't part of them.  A Dialect can strip a prefix such as
+a timestamp from each line, and can read dumps in other formats, like those
+written by the Linux kernel's print_hex_dump (KernelHexDump) or gdb (GDB).
+
+  > # request
+  00000000  "GET / HTTP/1.1\r\n\r\n"
+  < # response
+  00000000  "HTTP/1.1 204 No Content\r\n\r\n"
 */
 package lhex
@@ -0,0 +1,298 @@
+package lhextest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnesting/lhex"
+)
+
+// errMismatch is returned by a Conn once the client has sent data differing from the
+// transcript.
+var errMismatch = errors.New("lhextest: data written doesn't match transcript")
+
+// errStalled is returned by a Conn once a Read has given up waiting for the client to send
+// data.
+var errStalled = errors.New("lhextest: read waiting for data the client never sent")
+
+// timeoutError is returned by a Conn when a deadline passes.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "lhextest: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// defaultStall is how long a Read waits for the client to send data by default.
+const defaultStall = 10 * time.Second
+
+// Conn is a net.Conn that plays the server side of a transcript, for testing clients without
+// a real server.  Reads return the data the transcript shows as received, and writes are
+// checked against the data it shows as sent, with wildcards in the transcript matching
+// anything.
+//
+// Data is received only once the client has sent everything preceding it in the transcript,
+// so a Read blocks until the client has written the request a response answers.  A Read with
+// no deadline that waits too long for this reports a failure, showing the data the client was
+// expected to send next.  Once all of the received data has been read, Read returns io.EOF.
+//
+// Deadlines work as they do for other net.Conns: once one passes, reads or writes fail with a
+// net.Error whose Timeout method returns true, which is os.ErrDeadlineExceeded from Go 1.15.
+type Conn struct {
+	t        testing.TB
+	messages []lhex.Message
+	labels   *lhex.Labels // labels for the sent data
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	send    int   // index of the next sent message
+	sendPos int   // bytes of it written so far
+	recv    int   // index of the next received message
+	recvPos int   // bytes of it read so far
+	err     error // set once the client has diverged from the transcript
+	closed  bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	stall         time.Duration // how long a Read waits without a deadline
+}
+
+// NewConn returns a Conn playing the server side of tr, reporting any difference between
+// what the client sends and the transcript as a failure of t.
+func NewConn(t testing.TB, tr *lhex.Transcript) *Conn {
+	c := &Conn{t: t, messages: tr.Messages, stall: defaultStall}
+	if tr.Sent != nil {
+		c.labels = tr.Sent.Labels
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.send = c.next(0, lhex.Sent)
+	c.recv = c.next(0, lhex.Received)
+	return c
+}
+
+// Replay loads the transcript at path and returns a Conn playing its server side.
+func Replay(t testing.TB, path string) *Conn {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("loading transcript: %v", err)
+	}
+	defer f.Close()
+	tr, err := lhex.DecodeTranscript(f)
+	if err != nil {
+		t.Fatalf("loading transcript %s: %v", path, err)
+	}
+	return NewConn(t, tr)
+}
+
+// next returns the index of the first message from i on sent in direction dir.
+func (c *Conn) next(i int, dir lhex.Direction) int {
+	for i < len(c.messages) && c.messages[i].Dir != dir {
+		i++
+	}
+	return i
+}
+
+// SetStallTimeout sets how long a Read with no deadline waits for the client to send the data
+// it needs before reporting a failure, which is 10 seconds by default.
+func (c *Conn) SetStallTimeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stall = d
+}
+
+// Read reads data the transcript shows the server sending.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := time.Now()
+	for {
+		switch {
+		case c.closed:
+			return 0, io.ErrClosedPipe
+		case c.err != nil:
+			return 0, c.err
+		case c.recv == len(c.messages):
+			return 0, io.EOF
+		case c.send > c.recv:
+			m := c.messages[c.recv]
+			n = copy(p, m.Data[c.recvPos:])
+			if c.recvPos += n; c.recvPos == len(m.Data) {
+				c.recv, c.recvPos = c.next(c.recv+1, lhex.Received), 0
+			}
+			return n, nil
+		}
+		now, wake := time.Now(), c.readDeadline
+		if !wake.IsZero() && !now.Before(wake) {
+			return 0, timeoutError{}
+		}
+		if wake.IsZero() {
+			if wake = start.Add(c.stall); !now.Before(wake) {
+				m := c.messages[c.send]
+				c.err = errStalled
+				c.cond.Broadcast()
+				c.t.Errorf("read waiting %v for the client to send the rest of the transcript (-want +got):\n%s",
+					c.stall, diff(m.Data, m.Data[:c.sendPos], m.Offset, c.labels))
+				return 0, c.err
+			}
+		}
+		timer := time.AfterFunc(wake.Sub(now), c.wake)
+		c.cond.Wait()
+		timer.Stop()
+	}
+}
+
+// wake wakes any Reads waiting, so they can check their deadlines.
+func (c *Conn) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// Write checks p against the data the transcript shows the client sending.  If it differs,
+// Write reports a failure with a labelled diff of the message involved and returns an error,
+// as does every later call.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	} else if c.err != nil {
+		return 0, c.err
+	} else if !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline) {
+		return 0, timeoutError{}
+	}
+	for n < len(p) {
+		if c.send == len(c.messages) {
+			c.mismatch(nil, p[n:])
+			return n, c.err
+		}
+		m := c.messages[c.send]
+		k := len(m.Data) - c.sendPos
+		if k > len(p)-n {
+			k = len(p) - n
+		}
+		if !matches(p[n:n+k], m.Data[c.sendPos:], maskFrom(m.Mask, c.sendPos)) {
+			c.mismatch(&m, p[n:])
+			return n, c.err
+		}
+		n += k
+		if c.sendPos += k; c.sendPos == len(m.Data) {
+			c.send, c.sendPos = c.next(c.send+1, lhex.Sent), 0
+			c.cond.Broadcast()
+		}
+	}
+	return n, nil
+}
+
+// matches reports whether got matches the start of want, whose known bits are given by mask,
+// or all known if mask is nil.
+func matches(got, want, mask []byte) bool {
+	for i, b := range got {
+		m := byte(0xFF)
+		if mask != nil {
+			m = mask[i]
+		}
+		if (b^want[i])&m != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// maskFrom returns mask[i:], or nil if mask is nil.
+func maskFrom(mask []byte, i int) []byte {
+	if mask == nil {
+		return nil
+	}
+	return mask[i:]
+}
+
+// mismatch reports that the client sent rest after the first c.sendPos bytes of m, which
+// differs from m, or that it sent rest after the end of the sent data if m is nil.
+func (c *Conn) mismatch(m *lhex.Message, rest []byte) {
+	c.t.Helper()
+	var want, got []byte
+	var ofs int64
+	if m != nil {
+		ofs = m.Offset
+		want = append([]byte(nil), m.Data...)
+		got = append(append(got, m.Data[:c.sendPos]...), rest...)
+		// Wildcards match whatever the client sent.
+		for i := range want {
+			if m.Mask != nil && i < len(got) {
+				want[i] = want[i]&m.Mask[i] | got[i]&^m.Mask[i]
+			}
+		}
+	} else {
+		got = rest
+		for _, m := range c.messages {
+			if m.Dir == lhex.Sent {
+				ofs = m.End()
+			}
+		}
+	}
+	c.err = errMismatch
+	c.cond.Broadcast()
+	c.t.Errorf("data written doesn't match transcript (-want +got):\n%s", diff(want, got, ofs, c.labels))
+}
+
+// Close closes the connection, reporting a failure if the client hadn't sent all of the data
+// in the transcript.
+func (c *Conn) Close() error {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.closed = true
+	c.cond.Broadcast()
+	if c.err == nil && c.send < len(c.messages) {
+		m := c.messages[c.send]
+		c.t.Errorf("connection closed before the client sent everything in the transcript (-want +got):\n%s",
+			diff(m.Data, m.Data[:c.sendPos], m.Offset, c.labels))
+	}
+	return nil
+}
+
+// addr is the address of either end of a Conn.
+type addr struct{}
+
+func (addr) Network() string { return "lhex" }
+func (addr) String() string  { return "transcript" }
+
+// LocalAddr returns a placeholder address.
+func (c *Conn) LocalAddr() net.Addr { return addr{} }
+
+// RemoteAddr returns a placeholder address.
+func (c *Conn) RemoteAddr() net.Addr { return addr{} }
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the time after which Read fails, or clears it if t is zero.  It applies
+// to Reads already waiting.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the time after which Write fails, or clears it if t is zero.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
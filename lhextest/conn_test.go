@@ -0,0 +1,145 @@
+package lhextest_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnesting/lhex"
+	"github.com/dnesting/lhex/lhextest"
+)
+
+var _ net.Conn = (*lhextest.Conn)(nil)
+
+func TestConn(t *testing.T) {
+	var r recorder
+	c := lhextest.Replay(&r, "testdata/session.lhex")
+
+	// The response only arrives once the request has been written.
+	done := make(chan string)
+	go func() {
+		data, err := ioutil.ReadAll(c)
+		if err != nil {
+			t.Errorf("ReadAll: %v", err)
+		}
+		done <- string(data)
+	}()
+	for _, s := range []string{"PI", "NG 1234\n"} {
+		if _, err := io.WriteString(c, s); err != nil {
+			t.Fatalf("Write(%q): %v", s, err)
+		}
+	}
+	if got := <-done; got != "PONG\n" {
+		t.Errorf("read %q, want %q", got, "PONG\n")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if r.failed {
+		t.Errorf("Conn should accept a matching conversation, got:\n%s", r.msg)
+	}
+}
+
+func TestConnMismatch(t *testing.T) {
+	var r recorder
+	c := lhextest.Replay(&r, "testdata/session.lhex")
+	if n, err := io.WriteString(c, "PONG 1234\n"); n != 0 || err == nil {
+		t.Errorf("Write of mismatched data = %d, %v, want an error", n, err)
+	}
+	if !r.failed {
+		t.Fatalf("Conn should fail on mismatched data")
+	}
+	expected := `  :request
+- 00000000  50 49 4E 47 20                                    |PING |
++ 00000000  50 4F 4E 47 20                                    |PONG |
+  :nonce
+  00000005  31 32 33 34 0A                                    |1234.|
+`
+	if r.msg != expected {
+		t.Errorf("Conn should report a labelled diff, expected:\n%s\ngot:\n%s", expected, r.msg)
+	}
+	if _, err := c.Read(make([]byte, 10)); err == nil {
+		t.Errorf("Read after a mismatch should fail")
+	}
+}
+
+func TestConnExtraData(t *testing.T) {
+	tr, err := lhex.DecodeTranscript(strings.NewReader(">\n00000000  \"hi\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r recorder
+	c := lhextest.NewConn(&r, tr)
+	if n, err := io.WriteString(c, "hi!"); n != 2 || err == nil {
+		t.Errorf("Write past the end of the transcript = %d, %v, want 2 and an error", n, err)
+	}
+	expected := "+ 00000002  21                                                |!|\n"
+	if r.msg != expected {
+		t.Errorf("Conn should report the extra data, expected:\n%s\ngot:\n%s", expected, r.msg)
+	}
+}
+
+func TestConnClosedEarly(t *testing.T) {
+	var r recorder
+	c := lhextest.Replay(&r, "testdata/session.lhex")
+	io.WriteString(c, "PING")
+	c.Close()
+	if !r.failed {
+		t.Errorf("Close should fail if the request wasn't sent in full")
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	var r recorder
+	c := lhextest.Replay(&r, "testdata/session.lhex")
+	c.SetDeadline(time.Now().Add(-time.Second))
+	if _, err := c.Read(make([]byte, 10)); !isTimeout(err) {
+		t.Errorf("Read after the deadline should time out, got %v", err)
+	}
+	if _, err := io.WriteString(c, "PING"); !isTimeout(err) {
+		t.Errorf("Write after the deadline should time out, got %v", err)
+	}
+
+	// A deadline set while a Read waits applies to it.
+	c.SetDeadline(time.Time{})
+	done := make(chan error)
+	go func() {
+		_, err := c.Read(make([]byte, 10))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if err := <-done; !isTimeout(err) {
+		t.Errorf("waiting Read should time out, got %v", err)
+	}
+	if r.failed {
+		t.Errorf("a timeout shouldn't be a failure, got:\n%s", r.msg)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestConnStalled(t *testing.T) {
+	var r recorder
+	c := lhextest.Replay(&r, "testdata/session.lhex")
+	c.SetStallTimeout(10 * time.Millisecond)
+	io.WriteString(c, "PING")
+	if _, err := c.Read(make([]byte, 10)); err == nil {
+		t.Errorf("Read waiting for data the client never sends should fail")
+	}
+	expected := `  :request
+- 00000000  50 49 4E 47 20                                    |PING |
++ 00000000  50 49 4E 47                                       |PING|
+- :nonce
+- 00000005  00 00 00 00 0A                                    |.....|
+`
+	if r.msg != expected {
+		t.Errorf("Read should report the data expected, expected:\n%s\ngot:\n%s", expected, r.msg)
+	}
+}
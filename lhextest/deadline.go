@@ -0,0 +1,11 @@
+//go:build go1.15
+// +build go1.15
+
+package lhextest
+
+import "os"
+
+// Is makes timeoutError match os.ErrDeadlineExceeded, as net.Conns' timeouts do.
+func (timeoutError) Is(err error) bool {
+	return err == os.ErrDeadlineExceeded
+}
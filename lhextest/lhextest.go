@@ -0,0 +1,235 @@
+// Package lhextest provides helpers for tests that compare binary data against
+// golden files in lhex format.
+//
+// A typical test looks like:
+//
+//	func TestEncode(t *testing.T) {
+//	    got := encode(input)
+//	    lhextest.Equal(t, "testdata/encode.lhex", got)
+//	}
+//
+// Running "go test -update" rewrites the golden files from the data the tests
+// produce, keeping any labels and comments the golden files already contain.
+// So that it can't collide with a flag of the same name, this package doesn't
+// define -update itself; the test package defines it, for instance with:
+//
+//	var _ = flag.Bool("update", false, "rewrite golden files")
+//
+// Conn plays back the server side of a transcript recorded in lhex format,
+// checking what a client sends against it:
+//
+//	func TestClient(t *testing.T) {
+//	    conn := lhextest.Replay(t, "testdata/session.lhex")
+//	    defer conn.Close()
+//	    client := NewClient(conn)
+//	    ...
+//	}
+package lhextest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+// maxSize is the most data a golden file may describe, since it's decoded into a
+// single buffer starting at offset 0.
+const maxSize = 64 << 20
+
+// contextLines is the number of unchanged lines shown around each difference by Diff.
+const contextLines = 2
+
+// Golden holds the decoded contents of a golden file.
+type Golden struct {
+	Data     []byte
+	Labels   *lhex.Labels
+	Comments *lhex.Comments
+}
+
+// Decode decodes a golden file from r.  Data is assumed to start at offset 0,
+// and any gaps in the input are filled with zeros.  Data past 64 MiB is an
+// error, rather than a reason to allocate that much.
+func Decode(r io.Reader) (*Golden, error) {
+	dec := lhex.NewDecoder(r)
+	g := &Golden{Labels: dec.Labels(), Comments: dec.Comments()}
+	var ofs int64
+	for {
+		chunk, err := ioutil.ReadAll(dec)
+		if err != nil {
+			return nil, err
+		}
+		end := ofs + int64(len(chunk))
+		if end > maxSize {
+			return nil, fmt.Errorf("golden file data extends to 0x%X, past the limit of 0x%X", end, maxSize)
+		}
+		if end > int64(len(g.Data)) {
+			g.Data = append(g.Data, make([]byte, int(end)-len(g.Data))...)
+		}
+		copy(g.Data[ofs:], chunk)
+		ofs += int64(len(chunk))
+
+		skip, err := dec.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if ofs += skip; ofs < 0 {
+			return nil, errors.New("golden file offset out of range")
+		}
+	}
+	return g, nil
+}
+
+// Load reads and decodes the golden file at path.
+func Load(path string) (*Golden, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// Encode writes data as a hex dump to w, using the labels and comments from g
+// if g is not nil.
+func Encode(w io.Writer, data []byte, g *Golden) error {
+	var labels *lhex.Labels
+	var comments *lhex.Comments
+	if g != nil {
+		labels, comments = g.Labels, g.Comments
+	}
+	dmp := lhex.NewDumper(w, labels)
+	dmp.SetComments(comments)
+	if _, err := dmp.Write(data); err != nil {
+		return err
+	}
+	return dmp.Close()
+}
+
+// updating reports whether the test binary defines an -update flag and it was
+// set.
+func updating() bool {
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	b, _ := g.Get().(bool)
+	return b
+}
+
+// Equal reports a test failure if got differs from the data in the golden file
+// at path, including a labelled hexdump diff of the two.  If the -update flag
+// defined by the test package was given, the golden file is instead rewritten
+// to contain got, preserving the labels and comments from the existing file.
+func Equal(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if updating() {
+		if err := Update(path, got); err != nil {
+			t.Fatalf("updating golden file: %v", err)
+		}
+		return
+	}
+	g, err := Load(path)
+	if err != nil {
+		t.Fatalf("loading golden file (run with -update to create it): %v", err)
+	}
+	if diff := Diff(g.Data, got, g.Labels); diff != "" {
+		t.Errorf("data does not match golden file %s (-want +got):\n%s", path, diff)
+	}
+}
+
+// Update rewrites the golden file at path to contain data, keeping the labels
+// and comments from the existing file, if any.
+func Update(path string, data []byte) error {
+	g, err := Load(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, data, g); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Diff returns a line-oriented diff between hex dumps of want and got, both
+// taken to start at offset 0 and labelled using labels.  Lines only in want are
+// prefixed with "-", lines only in got with "+".  Returns "" if want and got
+// are equal.
+func Diff(want, got []byte, labels *lhex.Labels) string {
+	return diff(want, got, 0, labels)
+}
+
+// diff is like Diff, but with want and got both starting at offset.
+func diff(want, got []byte, offset int64, labels *lhex.Labels) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	// Because both dumps share the same labels and starting offset, their lines
+	// correspond one-to-one up to the length of the shorter of the two.
+	wl := splitLines(lhex.Dump(want, offset, labels))
+	gl := splitLines(lhex.Dump(got, offset, labels))
+	n := len(wl)
+	if len(gl) > n {
+		n = len(gl)
+	}
+	changed := make([]bool, n)
+	for i := range changed {
+		changed[i] = i >= len(wl) || i >= len(gl) || wl[i] != gl[i]
+	}
+
+	var sb strings.Builder
+	skipping := false
+	for i := 0; i < n; i++ {
+		if !changed[i] {
+			if !nearChange(changed, i) {
+				if !skipping {
+					sb.WriteString("  ...\n")
+					skipping = true
+				}
+				continue
+			}
+			fmt.Fprintf(&sb, "  %s\n", wl[i])
+			skipping = false
+			continue
+		}
+		skipping = false
+		if i < len(wl) {
+			fmt.Fprintf(&sb, "- %s\n", wl[i])
+		}
+		if i < len(gl) {
+			fmt.Fprintf(&sb, "+ %s\n", gl[i])
+		}
+	}
+	return sb.String()
+}
+
+// nearChange reports whether line i is within contextLines of a changed line.
+func nearChange(changed []bool, i int) bool {
+	for j := i - contextLines; j <= i+contextLines; j++ {
+		if j >= 0 && j < len(changed) && changed[j] {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
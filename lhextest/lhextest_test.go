@@ -0,0 +1,126 @@
+package lhextest_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex/lhextest"
+)
+
+// The package leaves defining -update to its users, which TestUpdate relies on.
+var _ = flag.Bool("update", false, "rewrite golden files")
+
+// recorder captures failures from the helpers under test.
+type recorder struct {
+	testing.TB
+	failed bool
+	msg    string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.msg = format
+	if len(args) > 0 {
+		r.msg = args[len(args)-1].(string)
+	}
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestEqual(t *testing.T) {
+	var r recorder
+	lhextest.Equal(&r, "testdata/greeting.lhex", []byte("Hello, world!\n"))
+	if r.failed {
+		t.Errorf("Equal should succeed against matching data, got:\n%s", r.msg)
+	}
+
+	r = recorder{}
+	lhextest.Equal(&r, "testdata/greeting.lhex", []byte("Hello, there!\n"))
+	if !r.failed {
+		t.Fatalf("Equal should fail against mismatched data")
+	}
+	expected := `  ...
+  00000000  48 65 6C 6C 6F 2C 20                              |Hello, |
+  :name
+- 00000007  77 6F 72 6C 64 21 0A                              |world!.|
++ 00000007  74 68 65 72 65 21 0A                              |there!.|
+`
+	if r.msg != expected {
+		t.Errorf("Equal should report a labelled diff, expected:\n%s\ngot:\n%s", expected, r.msg)
+	}
+}
+
+func TestDiffContext(t *testing.T) {
+	want := make([]byte, 0x100)
+	got := make([]byte, 0x110)
+	got[0x80] = 1
+	expected := `  ...
+  00000060  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+  00000070  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+- 00000080  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
++ 00000080  01 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+  00000090  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+  000000A0  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+  ...
+  000000E0  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+  000000F0  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
++ 00000100  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+`
+	if actual := lhextest.Diff(want, got, nil); actual != expected {
+		t.Errorf("Diff should show changes with context, expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	if actual := lhextest.Diff(want, want, nil); actual != "" {
+		t.Errorf("Diff of equal data should be empty, got:\n%s", actual)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhextest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orig, err := ioutil.ReadFile("testdata/greeting.lhex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "greeting.lhex")
+	if err := ioutil.WriteFile(path, orig, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flag.Set("update", "true")
+	defer flag.Set("update", "false")
+	lhextest.Equal(t, path, []byte("Hello, there!\n"))
+
+	actual, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(string(orig), "77 6F 72 6C 64", "74 68 65 72 65", 1)
+	expected = strings.Replace(expected, "world", "there", 1)
+	if string(actual) != expected {
+		t.Errorf("updated golden file should keep labels and comments, expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestDecodeLimit(t *testing.T) {
+	g, err := lhextest.Decode(strings.NewReader("00000000  01 02\n00000010  03\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Data) != 0x11 || g.Data[0x10] != 3 {
+		t.Errorf("Decode should fill the gap with zeros, got % X", g.Data)
+	}
+	if _, err := lhextest.Decode(strings.NewReader("7FFFFFFFFFFFFF00  01 02\n")); err == nil {
+		t.Errorf("Decode should refuse data at a high offset instead of allocating up to it")
+	}
+}
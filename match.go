@@ -0,0 +1,204 @@
+package lhex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Mismatch describes a run of bytes that don't match a template.
+type Mismatch struct {
+	Offset int64  // offset of the first byte that differs
+	Label  string // Offset relative to the template's labels, as from Labels.Symbolize
+	Want   []byte // expected bytes starting at Offset, with wildcard bits 0
+	Mask   []byte // known bits of Want, or nil if all are known
+	Got    []byte // actual bytes starting at Offset, which may be shorter than Want at EOF
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("0x%X (%s): want %s, got % X", m.Offset, m.Label, formatMasked(m.Want, m.Mask), m.Got)
+}
+
+// formatMasked formats data like "% X", but with '?' in place of unknown hex digits.
+func formatMasked(data, mask []byte) string {
+	sb := make([]byte, 0, len(data)*3)
+	for i, b := range data {
+		h, m := hexTab[b], maskAt(mask, i)
+		if m&0xF0 != 0xF0 {
+			h[0] = '?'
+		}
+		if m&0x0F != 0x0F {
+			h[1] = '?'
+		}
+		if i > 0 {
+			sb = append(sb, ' ')
+		}
+		sb = append(sb, h[0], h[1])
+	}
+	return string(sb)
+}
+
+// maxMismatch is the most bytes a single Mismatch will describe.
+const maxMismatch = 16
+
+// Match compares r against template, whose segments give the bytes expected at each offset.
+// Wildcard bits in the template match anything.  Returns the first limit runs of bytes that
+// don't match, or all of them if limit <= 0, each describing at most 16 bytes.  Bytes past the
+// end of r don't match.  If r matches, the result is empty.
+func Match(r io.ReaderAt, template *File, limit int) ([]Mismatch, error) {
+	var mm []Mismatch
+	for _, s := range template.Segments {
+		got := make([]byte, len(s.Data))
+		n, err := r.ReadAt(got, s.Offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if mm = s.mismatches(mm, got[:n], template.Labels, limit); limit > 0 && len(mm) >= limit {
+			break
+		}
+	}
+	return mm, nil
+}
+
+// MatchBytes is like Match, but compares data, taken to start at offset 0.
+func MatchBytes(data []byte, template *File, limit int) []Mismatch {
+	mm, _ := Match(bytes.NewReader(data), template, limit)
+	return mm
+}
+
+// matches reports whether b matches want with the given mask, which may be nil.
+func matches(b, want, mask byte) bool {
+	return (b^want)&mask == 0
+}
+
+// mismatches appends to mm the runs of bytes in got differing from s, stopping once mm holds
+// limit entries if limit > 0.  got may be shorter than s.Data.
+func (s Segment) mismatches(mm []Mismatch, got []byte, labels *Labels, limit int) []Mismatch {
+	for i := 0; i < len(s.Data); i++ {
+		if i < len(got) && matches(got[i], s.Data[i], maskAt(s.Mask, i)) {
+			continue
+		}
+		if limit > 0 && len(mm) >= limit {
+			break
+		}
+		start := i
+		for i < len(s.Data) && i-start < maxMismatch && (i >= len(got) || !matches(got[i], s.Data[i], maskAt(s.Mask, i))) {
+			i++
+		}
+		m := Mismatch{
+			Offset: s.Offset + int64(start),
+			Label:  labels.Symbolize(s.Offset + int64(start)),
+			Want:   s.Data[start:i],
+		}
+		if s.Mask != nil {
+			m.Mask = s.Mask[start:i]
+		}
+		if start < len(got) {
+			end := i
+			if end > len(got) {
+				end = len(got)
+			}
+			m.Got = got[start:end]
+		}
+		mm = append(mm, m)
+		i--
+	}
+	return mm
+}
+
+// findChunk is how much of the input FindAll examines at once.
+const findChunk = 1 << 20
+
+// MaxFindSpan is the most bytes a template given to FindAll may span, from the start of its
+// first segment to the end of its last, since FindAll holds that much of the input in memory
+// along with each chunk it examines.
+const MaxFindSpan = 16 << 20
+
+// FindAll returns the offsets within the first size bytes of r at which template matches, in
+// order.  The template's offsets are taken relative to its first segment, so an offset in the
+// result is where the template's first byte was found.  Matches may overlap.  Templates
+// spanning more than MaxFindSpan bytes are rejected.
+func FindAll(r io.ReaderAt, size int64, template *File) ([]int64, error) {
+	if len(template.Segments) == 0 {
+		return nil, nil
+	}
+	base := template.Segments[0].Offset
+	span := template.Segments[len(template.Segments)-1].End() - base
+	if span > MaxFindSpan {
+		return nil, fmt.Errorf("template spans %d bytes, more than the limit of %d", span, MaxFindSpan)
+	} else if span > size {
+		return nil, nil
+	}
+
+	// Look for the longest run of exact bytes in the template, and only check the template
+	// where that is found.
+	var anchor []byte
+	var anchorOfs int64
+	for _, s := range template.Segments {
+		for i := 0; i < len(s.Data); {
+			j := i
+			for j < len(s.Data) && (s.Mask == nil || s.Mask[j] == 0xFF) {
+				j++
+			}
+			if j-i > len(anchor) {
+				anchor, anchorOfs = s.Data[i:j], s.Offset-base+int64(i)
+			}
+			i = j + 1
+		}
+	}
+
+	var found []int64
+	buf := make([]byte, min64(findChunk+span, size))
+	for pos := int64(0); pos+span <= size; pos += findChunk {
+		n, err := r.ReadAt(buf[:min64(int64(len(buf)), size-pos)], pos)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		window := buf[:n]
+		check := func(at int64) {
+			if at < 0 || at >= findChunk || at+span > int64(len(window)) {
+				return
+			}
+			for _, s := range template.Segments {
+				start := at + s.Offset - base
+				got := window[start : start+int64(len(s.Data))]
+				for i, b := range got {
+					if !matches(b, s.Data[i], maskAt(s.Mask, i)) {
+						return
+					}
+				}
+			}
+			found = append(found, pos+at)
+		}
+		if len(anchor) == 0 {
+			for at := int64(0); at < findChunk; at++ {
+				check(at)
+			}
+			continue
+		}
+		for i := 0; ; {
+			j := bytes.Index(window[i:], anchor)
+			if j < 0 {
+				break
+			}
+			check(int64(i+j) - anchorOfs)
+			i += j + 1
+		}
+	}
+	return found, nil
+}
+
+// maskAt returns mask[i], or 0xFF if mask is nil.
+func maskAt(mask []byte, i int) byte {
+	if mask == nil {
+		return 0xFF
+	}
+	return mask[i]
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,62 @@
+package lhex_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+var matchTemplate = `
+:magic
+00000000  7F 45 4C 46                                       |.ELF|
+:nonce
+00000004  ?? ?? ?? ??                                       |????|
+:kind
+00000008  0?                                                |?|
+`
+
+func TestMatch(t *testing.T) {
+	template, err := lhex.Decode(strings.NewReader(matchTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm := lhex.MatchBytes([]byte("\x7FELF\x01\x02\x03\x04\x05"), template, 0); len(mm) != 0 {
+		t.Errorf("data should match the template, got %v", mm)
+	}
+
+	mm := lhex.MatchBytes([]byte("\x7FEL!\x01\x02\x03\x04\x15"), template, 0)
+	if got := fmt.Sprint(mm); got != "[0x3 (magic+0x3): want 46, got 21 0x8 (kind): want 0?, got 15]" {
+		t.Errorf("mismatches should be reported with labels, got %s", got)
+	}
+	if mm := lhex.MatchBytes([]byte("\x7FEL!\x01\x02\x03\x04\x15"), template, 1); len(mm) != 1 {
+		t.Errorf("limit should stop at the first mismatch, got %v", mm)
+	}
+	if mm := lhex.MatchBytes([]byte("\x7FELF\x01"), template, 0); len(mm) != 1 || mm[0].Offset != 5 || len(mm[0].Want) != 4 || mm[0].Got != nil {
+		t.Errorf("bytes missing from short data should mismatch, got %v", mm)
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	template, err := lhex.Decode(strings.NewReader(matchTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3<<20)
+	want := []int64{0x10, 1<<20 - 4, 2<<20 + 7, 3<<20 - 9}
+	for i, ofs := range want {
+		copy(data[ofs:], []byte{0x7F, 'E', 'L', 'F', byte(i), 0, 0, 0, 0x0A})
+	}
+	copy(data[0x100:], "\x7FELF\x00\x00\x00\x00\x10") // wrong kind
+	found, err := lhex.FindAll(bytes.NewReader(data), int64(len(data)), template)
+	if err != nil || fmt.Sprint(found) != fmt.Sprint(want) {
+		t.Errorf("FindAll should find %X, got %X, %v", want, found, err)
+	}
+
+	sparse := &lhex.File{Segments: []lhex.Segment{{Offset: 0, Data: []byte{1}}, {Offset: 1 << 40, Data: []byte{2}}}}
+	if _, err := lhex.FindAll(bytes.NewReader(data), int64(len(data)), sparse); err == nil {
+		t.Errorf("FindAll should reject a template spanning more than MaxFindSpan")
+	}
+}
@@ -0,0 +1,139 @@
+package objfile
+
+import (
+	"debug/elf"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dnesting/lhex"
+)
+
+// ELFOptions controls how ELF converts an ELF file.
+type ELFOptions struct {
+	// Sections lists the names of the sections to dump.  If empty, every section with
+	// contents in the file is dumped.
+	Sections []string
+
+	// Segments dumps the file's PT_LOAD segments instead of its sections.
+	Segments bool
+
+	// VirtualAddresses places data at its address in memory rather than its offset in the
+	// file.  Sections that aren't loaded into memory are omitted.
+	VirtualAddresses bool
+}
+
+// ELF returns the contents of f as an lhex.File.  Each section dumped gets a label
+// named after it, and each symbol within the dumped data gets a label too, with comments
+// giving the section's type, flags and size, and the symbol's type, binding and size.
+func ELF(f *elf.File, opts ELFOptions) (*lhex.File, error) {
+	b := newBuilder()
+
+	// place returns where an address in the given section belongs in the output.
+	place := func(s *elf.Section, addr uint64) (int64, bool) {
+		rel := addr
+		if f.Type != elf.ET_REL {
+			rel -= s.Addr
+		}
+		switch {
+		case !opts.VirtualAddresses:
+			return int64(s.Offset + rel), s.Type != elf.SHT_NOBITS
+		case s.Flags&elf.SHF_ALLOC != 0:
+			return int64(s.Addr + rel), true
+		}
+		return 0, false
+	}
+
+	if opts.Segments {
+		for i, p := range f.Progs {
+			if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+				continue
+			}
+			data := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(data, 0); err != nil {
+				return nil, fmt.Errorf("reading segment %d: %v", i, err)
+			}
+			ofs := int64(p.Off)
+			if opts.VirtualAddresses {
+				if err := checkAddr(p.Vaddr, p.Filesz); err != nil {
+					return nil, fmt.Errorf("segment %d: %v", i, err)
+				}
+				ofs = int64(p.Vaddr)
+			}
+			b.data(ofs, data)
+			b.label(fmt.Sprintf("load%d", i), ofs)
+			b.comment(ofs, "segment %d: %s %s, offset 0x%X, vaddr 0x%X, filesz 0x%X, memsz 0x%X",
+				i, p.Type, p.Flags, p.Off, p.Vaddr, p.Filesz, p.Memsz)
+		}
+	} else {
+		want := make(map[string]bool)
+		for _, name := range opts.Sections {
+			if f.Section(name) == nil {
+				return nil, fmt.Errorf("no section %q", name)
+			}
+			want[name] = true
+		}
+		for _, s := range f.Sections {
+			if s.Type == elf.SHT_NULL || s.Type == elf.SHT_NOBITS || s.FileSize == 0 || len(want) > 0 && !want[s.Name] {
+				continue
+			}
+			ofs, ok := place(s, s.Addr)
+			if !ok {
+				continue
+			}
+			if opts.VirtualAddresses {
+				if err := checkAddr(s.Addr, s.FileSize); err != nil {
+					return nil, fmt.Errorf("section %s: %v", s.Name, err)
+				}
+			}
+			data := make([]byte, s.FileSize)
+			if _, err := s.ReadAt(data, 0); err != nil {
+				return nil, fmt.Errorf("reading section %s: %v", s.Name, err)
+			}
+			b.data(ofs, data)
+		}
+	}
+
+	// Label the start of every section within the data, including those found in segments.
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		if ofs, ok := place(s, s.Addr); ok && b.contains(ofs) {
+			name := b.label(s.Name, ofs)
+			b.comment(ofs, "section %s: %s %s, addr 0x%X, offset 0x%X, size 0x%X",
+				name, s.Type, s.Flags, s.Addr, s.Offset, s.Size)
+		}
+	}
+
+	syms, _ := f.Symbols()
+	dyn, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dyn...) {
+		typ := elf.ST_TYPE(sym.Info)
+		if sym.Name == "" || typ == elf.STT_SECTION || typ == elf.STT_FILE ||
+			sym.Section == elf.SHN_UNDEF || sym.Section >= elf.SHN_LORESERVE || int(sym.Section) >= len(f.Sections) {
+			continue
+		}
+		ofs, ok := place(f.Sections[sym.Section], sym.Value)
+		if !ok || !b.contains(ofs) {
+			continue
+		}
+		name := b.label(sym.Name, ofs)
+		text := fmt.Sprintf("symbol %s: %s %s, size 0x%X",
+			name, strings.TrimPrefix(typ.String(), "STT_"), strings.TrimPrefix(elf.ST_BIND(sym.Info).String(), "STB_"), sym.Size)
+		if name != sym.Name {
+			text += fmt.Sprintf(" (%s)", sym.Name)
+		}
+		b.comment(ofs, "%s", text)
+	}
+	return b.file(), nil
+}
+
+// checkAddr returns an error if the n bytes at addr would extend past the largest offset an
+// lhex.File can hold.
+func checkAddr(addr, n uint64) error {
+	if addr > math.MaxInt64 || n > math.MaxInt64-addr {
+		return fmt.Errorf("address 0x%X is past the largest offset, 0x%X; use file offsets instead", addr, uint64(math.MaxInt64))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package objfile_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+	"github.com/dnesting/lhex/objfile"
+)
+
+// testdata/hello.elf is built from testdata/hello.c with:
+//
+//	gcc -Os -nostdlib -static -fno-asynchronous-unwind-tables -fno-pie -no-pie \
+//	    -Wl,--build-id=none -Wl,-z,max-page-size=0x10 -Wl,-z,noseparate-code \
+//	    -o hello.elf hello.c
+
+func openHello(t *testing.T) *elf.File {
+	f, err := elf.Open("testdata/hello.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestELF(t *testing.T) {
+	f := openHello(t)
+	defer f.Close()
+
+	out, err := objfile.ELF(f, objfile.ELFOptions{Sections: []string{".text", ".data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Segments) != 2 || out.Segments[0].Offset != 0xE8 || len(out.Segments[0].Data) != 0x14 || out.Segments[1].Offset != 0x110 {
+		t.Fatalf(".text and .data should be dumped at their file offsets, got %v", out.Segments)
+	}
+	for name, want := range map[string]int64{".text": 0xE8, "add": 0xE8, "_start": 0xF2, ".data": 0x110, "counter": 0x110} {
+		if ofs, ok := out.Labels.Get(name); !ok || ofs != want {
+			t.Errorf("label %s should be at 0x%X, got 0x%X, %v", name, want, ofs, ok)
+		}
+	}
+	if _, ok := out.Labels.Get("greeting"); ok {
+		t.Errorf("symbols outside the dumped sections should not be labelled")
+	}
+	if c := strings.Join(out.Comments.Get(0xF2), "\n"); c != "symbol _start: FUNC GLOBAL, size 0xA" {
+		t.Errorf("_start should have a comment describing it, got %q", c)
+	}
+	if c := strings.Join(out.Comments.Get(0x110), "\n"); !strings.HasPrefix(c, "section .data: SHT_PROGBITS SHF_WRITE+SHF_ALLOC, addr 0x400110") {
+		t.Errorf(".data should have a comment describing it, got %q", c)
+	}
+
+	out, err = objfile.ELF(f, objfile.ELFOptions{Segments: true, VirtualAddresses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Segments) != 2 || out.Segments[0].Offset != 0x400000 || out.Segments[1].Offset != 0x400110 {
+		t.Fatalf("loadable segments should be dumped at their addresses, got %v", out.Segments)
+	}
+	for name, want := range map[string]int64{"load0": 0x400000, "load1": 0x400110, "greeting": 0x400100} {
+		if ofs, ok := out.Labels.Get(name); !ok || ofs != want {
+			t.Errorf("label %s should be at 0x%X, got 0x%X, %v", name, want, ofs, ok)
+		}
+	}
+
+	// The dump should decode to the same thing.
+	var buf bytes.Buffer
+	if _, err := out.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	back, err := lhex.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decoding the ELF dump failed: %v", err)
+	}
+	if ofs, _ := back.Labels.Get("greeting"); ofs != 0x400100 || len(back.Segments) != 2 {
+		t.Errorf("decoded dump should match, got greeting at 0x%X and %d segments", ofs, len(back.Segments))
+	}
+
+	if _, err := objfile.ELF(f, objfile.ELFOptions{Sections: []string{".nope"}}); err == nil {
+		t.Errorf("asking for a missing section should fail")
+	}
+}
+
+func TestELFHighAddresses(t *testing.T) {
+	// Move hello.elf's segments and sections into the upper half of the address space, as in
+	// a kernel, by patching the addresses in its program and section headers.
+	raw, err := ioutil.ReadFile("testdata/hello.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const high = 0xFFFFFFFF00000000
+	move := func(at uint64) {
+		if addr := binary.LittleEndian.Uint64(raw[at:]); addr != 0 {
+			binary.LittleEndian.PutUint64(raw[at:], addr+high)
+		}
+	}
+	phoff, phnum := binary.LittleEndian.Uint64(raw[0x20:]), binary.LittleEndian.Uint16(raw[0x38:])
+	for i := uint64(0); i < uint64(phnum); i++ {
+		move(phoff + i*0x38 + 0x10) // p_vaddr
+	}
+	shoff, shnum := binary.LittleEndian.Uint64(raw[0x28:]), binary.LittleEndian.Uint16(raw[0x3C:])
+	for i := uint64(0); i < uint64(shnum); i++ {
+		move(shoff + i*0x40 + 0x10) // sh_addr
+	}
+	f, err := elf.NewFile(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Progs[0].Vaddr != 0xFFFFFFFF00400000 {
+		t.Fatalf("patching should have moved the first segment, got vaddr 0x%X", f.Progs[0].Vaddr)
+	}
+
+	if _, err := objfile.ELF(f, objfile.ELFOptions{Segments: true, VirtualAddresses: true}); err == nil {
+		t.Errorf("segments past the largest offset should be refused")
+	}
+	if _, err := objfile.ELF(f, objfile.ELFOptions{VirtualAddresses: true}); err == nil {
+		t.Errorf("sections past the largest offset should be refused")
+	}
+	out, err := objfile.ELF(f, objfile.ELFOptions{Segments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ofs, ok := out.Labels.Get("load1"); !ok || ofs != 0x110 || len(out.Segments) != 2 {
+		t.Errorf("file offsets should be unaffected, got load1 at 0x%X, %v and %d segments", ofs, ok, len(out.Segments))
+	}
+}
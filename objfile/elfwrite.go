@@ -0,0 +1,305 @@
+package objfile
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/dnesting/lhex"
+)
+
+// WriteELFOptions controls how WriteELF builds an ELF file.
+type WriteELFOptions struct {
+	Class   elf.Class   // ELFCLASS32 or ELFCLASS64 (the default)
+	Data    elf.Data    // ELFDATA2LSB (the default) or ELFDATA2MSB
+	Machine elf.Machine // e_machine, EM_NONE by default
+	Type    elf.Type    // e_type, ET_EXEC by default
+	Entry   uint64      // e_entry
+}
+
+// WriteELF writes the contents of f to w as an ELF file.  Each run of contiguous data in f
+// becomes a PT_LOAD segment whose virtual and physical addresses are its offset, along with a
+// section named ".load<n>" covering the same bytes.  Segments are placed in the file at the
+// same offset within a page as their address, so that the result can be loaded.  Each label
+// becomes a global symbol whose value is its offset, in the section containing it or absolute
+// if none does.  Labels at offsets the ELF class can't represent are omitted.  Wildcard bytes
+// are written as zeros.
+func WriteELF(w io.Writer, f *lhex.File, opts WriteELFOptions) error {
+	if opts.Class == elf.ELFCLASSNONE {
+		opts.Class = elf.ELFCLASS64
+	}
+	if opts.Data == elf.ELFDATANONE {
+		opts.Data = elf.ELFDATA2LSB
+	}
+	if opts.Type == elf.ET_NONE {
+		opts.Type = elf.ET_EXEC
+	}
+	ew := &elfWriter{w: w, class: opts.Class}
+	switch opts.Data {
+	case elf.ELFDATA2LSB:
+		ew.order = binary.LittleEndian
+	case elf.ELFDATA2MSB:
+		ew.order = binary.BigEndian
+	default:
+		return fmt.Errorf("unsupported ELF data encoding %v", opts.Data)
+	}
+	var ehsize, phentsize, shentsize, symsize, maxAddr int64
+	switch opts.Class {
+	case elf.ELFCLASS32:
+		ehsize, phentsize, shentsize, symsize, maxAddr = 52, 32, 40, 16, math.MaxUint32+1
+	case elf.ELFCLASS64:
+		ehsize, phentsize, shentsize, symsize, maxAddr = 64, 56, 64, 24, math.MaxInt64
+	default:
+		return fmt.Errorf("unsupported ELF class %v", opts.Class)
+	}
+
+	// Merge segments that abut, so each PT_LOAD covers a whole run of data.
+	var segs []lhex.Segment
+	for _, s := range f.Segments {
+		if s.Offset < 0 || s.End() > maxAddr {
+			return fmt.Errorf("segment at offset %d can't be represented in %v", s.Offset, opts.Class)
+		}
+		if n := len(segs); n > 0 && segs[n-1].End() == s.Offset {
+			segs[n-1].Data = append(segs[n-1].Data[:len(segs[n-1].Data):len(segs[n-1].Data)], s.Data...)
+		} else if len(s.Data) > 0 {
+			segs = append(segs, lhex.Segment{Offset: s.Offset, Data: s.Data})
+		}
+	}
+
+	// Sections are the null section, one per segment, then .symtab, .strtab and .shstrtab.
+	// Section indexes from SHN_LORESERVE up are reserved, which also keeps the number of
+	// program headers within e_phnum.
+	if len(segs)+4 > int(elf.SHN_LORESERVE) {
+		return fmt.Errorf("too many segments for ELF: %d", len(segs))
+	}
+	symtab := len(segs) + 1
+	strtab, shstrtab := symtab+1, symtab+2
+	var shnames strtabBuilder
+	var sects []elf.Section64
+	sects = append(sects, elf.Section64{})
+
+	pos := ehsize + phentsize*int64(len(segs))
+	for i, s := range segs {
+		pos += (s.Offset - pos) & (elfPageSize - 1)
+		sects = append(sects, elf.Section64{
+			Name:      shnames.add(fmt.Sprintf(".load%d", i)),
+			Type:      uint32(elf.SHT_PROGBITS),
+			Flags:     uint64(elf.SHF_ALLOC | elf.SHF_WRITE | elf.SHF_EXECINSTR),
+			Addr:      uint64(s.Offset),
+			Off:       uint64(pos),
+			Size:      uint64(len(s.Data)),
+			Addralign: 1,
+		})
+		pos += int64(len(s.Data))
+	}
+
+	var names strtabBuilder
+	var syms []elf.Sym64
+	syms = append(syms, elf.Sym64{})
+	if f.Labels != nil {
+		for _, l := range f.Labels.Range(0, maxAddr) {
+			shndx := uint16(elf.SHN_ABS)
+			for i, s := range segs {
+				if l.Offset >= s.Offset && l.Offset < s.End() {
+					shndx = uint16(i + 1)
+					break
+				}
+			}
+			syms = append(syms, elf.Sym64{
+				Name:  names.add(l.Name),
+				Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_NOTYPE),
+				Shndx: shndx,
+				Value: uint64(l.Offset),
+			})
+		}
+	}
+	pos = align(pos, 8)
+	sects = append(sects, elf.Section64{
+		Name:      shnames.add(".symtab"),
+		Type:      uint32(elf.SHT_SYMTAB),
+		Off:       uint64(pos),
+		Size:      uint64(symsize * int64(len(syms))),
+		Link:      uint32(strtab),
+		Info:      1, // all symbols but the first are global
+		Addralign: 8,
+		Entsize:   uint64(symsize),
+	})
+	pos += symsize * int64(len(syms))
+	sects = append(sects, elf.Section64{
+		Name:      shnames.add(".strtab"),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       uint64(pos),
+		Size:      uint64(len(names.buf)),
+		Addralign: 1,
+	})
+	pos += int64(len(names.buf))
+	shname := shnames.add(".shstrtab")
+	sects = append(sects, elf.Section64{
+		Name:      shname,
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       uint64(pos),
+		Size:      uint64(len(shnames.buf)),
+		Addralign: 1,
+	})
+	pos = align(pos+int64(len(shnames.buf)), 8)
+	shoff := pos
+
+	if shoff+shentsize*int64(len(sects)) > maxAddr {
+		return fmt.Errorf("too much data for %v", opts.Class)
+	}
+
+	ew.header(elf.FileHeader{
+		Class:   opts.Class,
+		Data:    opts.Data,
+		Version: elf.EV_CURRENT,
+		Type:    opts.Type,
+		Machine: opts.Machine,
+		Entry:   opts.Entry,
+	}, ehsize, phentsize, shentsize, len(segs), shoff, len(sects), shstrtab)
+	for i, s := range segs {
+		ew.prog(elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+			Off:    sects[i+1].Off,
+			Vaddr:  uint64(s.Offset),
+			Paddr:  uint64(s.Offset),
+			Filesz: uint64(len(s.Data)),
+			Memsz:  uint64(len(s.Data)),
+			Align:  elfPageSize,
+		})
+	}
+	for i, s := range segs {
+		ew.pad(int64(sects[i+1].Off))
+		ew.write(s.Data)
+	}
+	ew.pad(int64(sects[symtab].Off))
+	for _, sym := range syms {
+		ew.sym(sym)
+	}
+	ew.write(names.buf)
+	ew.write(shnames.buf)
+	ew.pad(shoff)
+	for _, s := range sects {
+		ew.section(s)
+	}
+	return ew.err
+}
+
+// elfPageSize is the page size that segments written by WriteELF are aligned to, as most
+// loaders expect.
+const elfPageSize = 0x1000
+
+// align rounds n up to a multiple of a.
+func align(n, a int64) int64 {
+	return (n + a - 1) / a * a
+}
+
+// strtabBuilder builds an ELF string table.
+type strtabBuilder struct {
+	buf []byte
+}
+
+// add adds s to the table and returns its index.
+func (t *strtabBuilder) add(s string) uint32 {
+	if len(t.buf) == 0 {
+		t.buf = append(t.buf, 0)
+	}
+	i := len(t.buf)
+	t.buf = append(append(t.buf, s...), 0)
+	return uint32(i)
+}
+
+// elfWriter writes the parts of an ELF file in the given class and byte order, keeping track
+// of its position and the first error encountered.
+type elfWriter struct {
+	w     io.Writer
+	class elf.Class
+	order binary.ByteOrder
+	pos   int64
+	err   error
+}
+
+func (ew *elfWriter) write(p []byte) {
+	if ew.err == nil {
+		var n int
+		n, ew.err = ew.w.Write(p)
+		ew.pos += int64(n)
+	}
+}
+
+// data writes the fixed-size value v.
+func (ew *elfWriter) data(v interface{}) {
+	if ew.err == nil {
+		ew.err = binary.Write(ew.w, ew.order, v)
+		ew.pos += int64(binary.Size(v))
+	}
+}
+
+// pad writes zeros up to pos.
+func (ew *elfWriter) pad(pos int64) {
+	if pos > ew.pos {
+		ew.write(make([]byte, pos-ew.pos))
+	}
+}
+
+func (ew *elfWriter) header(fh elf.FileHeader, ehsize, phentsize, shentsize int64, phnum int, shoff int64, shnum, shstrndx int) {
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS] = byte(fh.Class)
+	ident[elf.EI_DATA] = byte(fh.Data)
+	ident[elf.EI_VERSION] = byte(fh.Version)
+	ident[elf.EI_OSABI] = byte(fh.OSABI)
+	phoff := int64(0)
+	if phnum > 0 {
+		phoff = ehsize
+	}
+	if ew.class == elf.ELFCLASS32 {
+		ew.data(elf.Header32{
+			Ident: ident, Type: uint16(fh.Type), Machine: uint16(fh.Machine), Version: uint32(fh.Version),
+			Entry: uint32(fh.Entry), Phoff: uint32(phoff), Shoff: uint32(shoff),
+			Ehsize: uint16(ehsize), Phentsize: uint16(phentsize), Phnum: uint16(phnum),
+			Shentsize: uint16(shentsize), Shnum: uint16(shnum), Shstrndx: uint16(shstrndx),
+		})
+		return
+	}
+	ew.data(elf.Header64{
+		Ident: ident, Type: uint16(fh.Type), Machine: uint16(fh.Machine), Version: uint32(fh.Version),
+		Entry: fh.Entry, Phoff: uint64(phoff), Shoff: uint64(shoff),
+		Ehsize: uint16(ehsize), Phentsize: uint16(phentsize), Phnum: uint16(phnum),
+		Shentsize: uint16(shentsize), Shnum: uint16(shnum), Shstrndx: uint16(shstrndx),
+	})
+}
+
+func (ew *elfWriter) prog(p elf.Prog64) {
+	if ew.class == elf.ELFCLASS32 {
+		ew.data(elf.Prog32{
+			Type: p.Type, Off: uint32(p.Off), Vaddr: uint32(p.Vaddr), Paddr: uint32(p.Paddr),
+			Filesz: uint32(p.Filesz), Memsz: uint32(p.Memsz), Flags: p.Flags, Align: uint32(p.Align),
+		})
+		return
+	}
+	ew.data(p)
+}
+
+func (ew *elfWriter) section(s elf.Section64) {
+	if ew.class == elf.ELFCLASS32 {
+		ew.data(elf.Section32{
+			Name: s.Name, Type: s.Type, Flags: uint32(s.Flags), Addr: uint32(s.Addr), Off: uint32(s.Off),
+			Size: uint32(s.Size), Link: s.Link, Info: s.Info, Addralign: uint32(s.Addralign), Entsize: uint32(s.Entsize),
+		})
+		return
+	}
+	ew.data(s)
+}
+
+func (ew *elfWriter) sym(s elf.Sym64) {
+	if ew.class == elf.ELFCLASS32 {
+		ew.data(elf.Sym32{
+			Name: s.Name, Value: uint32(s.Value), Size: uint32(s.Size), Info: s.Info, Other: s.Other, Shndx: s.Shndx,
+		})
+		return
+	}
+	ew.data(s)
+}
@@ -0,0 +1,92 @@
+package objfile_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+	"github.com/dnesting/lhex/objfile"
+)
+
+func TestWriteELF(t *testing.T) {
+	in, err := lhex.Decode(strings.NewReader(`
+:start
+00001000  DE AD BE EF 01 02 03 04
+:mid
+00001008  05 06 07 08
+:gap
+00002000  CA FE BA BE
+:end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, class := range []elf.Class{elf.ELFCLASS32, elf.ELFCLASS64} {
+		var buf bytes.Buffer
+		opts := objfile.WriteELFOptions{Class: class, Data: elf.ELFDATA2MSB, Machine: elf.EM_MIPS, Entry: 0x1000}
+		if err := objfile.WriteELF(&buf, in, opts); err != nil {
+			t.Fatal(err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", class, err)
+		}
+		if f.Class != class || f.ByteOrder.String() != "BigEndian" || f.Machine != elf.EM_MIPS || f.Entry != 0x1000 {
+			t.Errorf("%v: header should reflect options, got %+v", class, f.FileHeader)
+		}
+		if len(f.Progs) != 2 || f.Progs[0].Vaddr != 0x1000 || f.Progs[0].Filesz != 12 || f.Progs[1].Vaddr != 0x2000 {
+			t.Errorf("%v: should have a PT_LOAD for each run of data, got %d", class, len(f.Progs))
+		}
+		for _, p := range f.Progs {
+			if p.Align == 0 || p.Off%p.Align != p.Vaddr%p.Align {
+				t.Errorf("%v: segment at 0x%X should be at the same offset within a page in the file, got offset 0x%X with alignment 0x%X", class, p.Vaddr, p.Off, p.Align)
+			}
+		}
+		syms, err := f.Symbols()
+		if err != nil {
+			t.Fatalf("%v: %v", class, err)
+		}
+		got := map[string]uint64{}
+		for _, s := range syms {
+			got[s.Name] = s.Value
+			if s.Name == "gap" && (int(s.Section) >= len(f.Sections) || f.Sections[s.Section].Name != ".load1") {
+				t.Errorf("%v: gap should be in .load1, got %v", class, s.Section)
+			}
+			if s.Name == "end" && s.Section != elf.SHN_ABS {
+				t.Errorf("%v: end lies outside any data and should be absolute, got %v", class, s.Section)
+			}
+		}
+		for name, want := range map[string]uint64{"start": 0x1000, "mid": 0x1008, "gap": 0x2000, "end": 0x2004} {
+			if got[name] != want {
+				t.Errorf("%v: symbol %s should be 0x%X, got 0x%X", class, name, want, got[name])
+			}
+		}
+
+		// Reading the result back should give what we started with.
+		back, err := objfile.ELF(f, objfile.ELFOptions{Segments: true, VirtualAddresses: true})
+		if err != nil {
+			t.Fatalf("%v: %v", class, err)
+		}
+		if len(back.Segments) != 2 || !bytes.Equal(back.Segments[0].Data, in.Segments[0].Data) || !bytes.Equal(back.Segments[1].Data, in.Segments[1].Data) {
+			t.Errorf("%v: data should survive a round trip, got %v", class, back.Segments)
+		}
+		if ofs, ok := back.Labels.Get("mid"); !ok || ofs != 0x1008 {
+			t.Errorf("%v: labels should survive a round trip, got mid=0x%X, %v", class, ofs, ok)
+		}
+	}
+
+	big := lhex.NewFile([]byte{1}, 1<<32)
+	if err := objfile.WriteELF(&bytes.Buffer{}, big, objfile.WriteELFOptions{Class: elf.ELFCLASS32}); err == nil {
+		t.Errorf("data beyond 4GB should not fit in ELFCLASS32")
+	}
+
+	many := &lhex.File{}
+	for i := 0; i < int(elf.SHN_LORESERVE); i++ {
+		many.Segments = append(many.Segments, lhex.Segment{Offset: int64(i) * 2, Data: []byte{1}})
+	}
+	if err := objfile.WriteELF(&bytes.Buffer{}, many, objfile.WriteELFOptions{}); err == nil {
+		t.Errorf("more segments than ELF can number should be an error")
+	}
+}
@@ -0,0 +1,225 @@
+package objfile
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/dnesting/lhex"
+)
+
+// MachOOptions controls how MachO converts a Mach-O file.
+type MachOOptions struct {
+	// Sections lists the sections to dump, named like "__text" or "__TEXT.__text".  If empty,
+	// every section with contents in the file is dumped.
+	Sections []string
+
+	// Segments dumps the file's segments instead of its sections.
+	Segments bool
+
+	// VirtualAddresses places data at its address in memory rather than its offset in the
+	// file.
+	VirtualAddresses bool
+
+	// Headers dumps the file's header and load commands, with comments describing each of
+	// their fields.
+	Headers bool
+}
+
+// machoFile holds what MachO needs to know about a file to place its contents.
+type machoFile struct {
+	f     *macho.File
+	r     io.ReaderAt
+	vaddr bool
+}
+
+// MachO returns the contents of the Mach-O file in r as an lhex.File.  Each section or
+// segment dumped gets a label named after it, and each symbol within the dumped data gets a
+// label too, with comments describing them.  Universal (fat) files are not supported.
+func MachO(r io.ReaderAt, opts MachOOptions) (*lhex.File, error) {
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	mf := &machoFile{f: f, r: r, vaddr: opts.VirtualAddresses}
+	b := newBuilder()
+
+	if opts.Headers {
+		if err := mf.headers(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Segments {
+		for _, l := range f.Loads {
+			s, ok := l.(*macho.Segment)
+			if !ok || s.Filesz == 0 {
+				continue
+			}
+			data, err := s.Data()
+			if err != nil {
+				return nil, fmt.Errorf("reading segment %s: %v", s.Name, err)
+			}
+			ofs := int64(s.Offset)
+			if opts.VirtualAddresses {
+				ofs = int64(s.Addr)
+			}
+			b.data(ofs, data)
+			name := b.label(s.Name, ofs)
+			b.comment(ofs, "segment %s: addr 0x%X, memsz 0x%X, offset 0x%X, filesz 0x%X, prot 0x%X",
+				name, s.Addr, s.Memsz, s.Offset, s.Filesz, s.Prot)
+		}
+	} else {
+		want := make(map[string]bool)
+		for _, name := range opts.Sections {
+			if machoSection(f, name) == nil {
+				return nil, fmt.Errorf("no section %q", name)
+			}
+			want[name] = true
+		}
+		for _, s := range f.Sections {
+			if s.Offset == 0 || s.Size == 0 || len(want) > 0 && !want[s.Name] && !want[s.Seg+"."+s.Name] {
+				continue
+			}
+			data, err := s.Data()
+			if err != nil {
+				return nil, fmt.Errorf("reading section %s.%s: %v", s.Seg, s.Name, err)
+			}
+			ofs, _ := mf.section(s, s.Addr)
+			b.data(ofs, data)
+		}
+	}
+
+	for _, s := range f.Sections {
+		if ofs, ok := mf.section(s, s.Addr); ok && b.contains(ofs) {
+			name := b.label(s.Seg+"."+s.Name, ofs)
+			b.comment(ofs, "section %s: addr 0x%X, size 0x%X, offset 0x%X, flags 0x%X",
+				name, s.Addr, s.Size, s.Offset, s.Flags)
+		}
+	}
+
+	if f.Symtab != nil {
+		for _, sym := range f.Symtab.Syms {
+			if sym.Name == "" || sym.Type&0xE0 != 0 || sym.Sect == 0 || int(sym.Sect) > len(f.Sections) { // skip N_STAB debugging entries
+				continue
+			}
+			ofs, ok := mf.section(f.Sections[sym.Sect-1], sym.Value)
+			if !ok || !b.contains(ofs) {
+				continue
+			}
+			name := b.label(sym.Name, ofs)
+			scope := "local"
+			if sym.Type&0x01 != 0 { // N_EXT
+				scope = "external"
+			}
+			text := fmt.Sprintf("symbol %s: %s, type 0x%X, desc 0x%X", name, scope, sym.Type, sym.Desc)
+			if name != sym.Name {
+				text += fmt.Sprintf(" (%s)", sym.Name)
+			}
+			b.comment(ofs, "%s", text)
+		}
+	}
+	return b.file(), nil
+}
+
+// machoSection returns the section called name, which may be qualified by its segment name
+// like "__TEXT.__text", or nil if there is none.
+func machoSection(f *macho.File, name string) *macho.Section {
+	for _, s := range f.Sections {
+		if s.Name == name || s.Seg+"."+s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// section returns where the address addr within section s belongs in the output, or false if
+// it has no place there, as for zero-filled sections when placing data at file offsets.
+func (mf *machoFile) section(s *macho.Section, addr uint64) (int64, bool) {
+	if mf.vaddr {
+		return int64(addr), true
+	}
+	return int64(uint64(s.Offset) + addr - s.Addr), s.Offset != 0 && addr-s.Addr < s.Size
+}
+
+// header returns where the header byte at ofs in the file belongs in the output, or false if
+// it isn't loaded into memory when placing data at virtual addresses.
+func (mf *machoFile) header(ofs int64) (int64, bool) {
+	if !mf.vaddr {
+		return ofs, true
+	}
+	for _, l := range mf.f.Loads {
+		if s, ok := l.(*macho.Segment); ok && uint64(ofs) >= s.Offset && uint64(ofs)-s.Offset < s.Filesz {
+			return int64(s.Addr + uint64(ofs) - s.Offset), true
+		}
+	}
+	return 0, false
+}
+
+// headers dumps and annotates the file's header and load commands.
+func (mf *machoFile) headers(b *builder) error {
+	size := int64(binary.Size(mf.f.FileHeader))
+	if mf.f.Magic == macho.Magic64 {
+		size += 4 // reserved
+	}
+	data, err := readData(mf.r, 0, size+int64(mf.f.Cmdsz))
+	if err != nil {
+		return fmt.Errorf("reading headers: %v", err)
+	}
+	at, ok := mf.header(0)
+	if !ok {
+		return nil // not loaded, as in object files
+	}
+	b.data(at, data)
+	b.label("mach_header", at)
+	b.fields(at, &mf.f.FileHeader, size)
+	if mf.f.Magic == macho.Magic64 {
+		b.comment(at+size-4, "Reserved: 0x%X", mf.f.ByteOrder.Uint32(data[size-4:]))
+	}
+
+	ofs := size
+	for i, l := range mf.f.Loads {
+		raw := l.Raw()
+		var v interface{}
+		var sect interface{}
+		switch macho.LoadCmd(mf.f.ByteOrder.Uint32(raw)) {
+		case macho.LoadCmdSegment:
+			v, sect = new(macho.Segment32), new(macho.Section32)
+		case macho.LoadCmdSegment64:
+			v, sect = new(macho.Segment64), new(macho.Section64)
+		case macho.LoadCmdSymtab:
+			v = new(macho.SymtabCmd)
+		case macho.LoadCmdDysymtab:
+			v = new(macho.DysymtabCmd)
+		case macho.LoadCmdDylib:
+			v = new(macho.DylibCmd)
+		case macho.LoadCmdRpath:
+			v = new(macho.RpathCmd)
+		default:
+			v = new(struct {
+				Cmd macho.LoadCmd
+				Len uint32
+			})
+		}
+		if err := binary.Read(bytes.NewReader(raw), mf.f.ByteOrder, v); err != nil {
+			return fmt.Errorf("reading load command %d: %v", i, err)
+		}
+		b.label(fmt.Sprintf("load_command%d", i), at+ofs)
+		b.fields(at+ofs, v, int64(len(raw)))
+		if sect != nil {
+			// Section headers follow the segment command.
+			n := int64(binary.Size(v))
+			for n+int64(binary.Size(sect)) <= int64(len(raw)) {
+				if err := binary.Read(bytes.NewReader(raw[n:]), mf.f.ByteOrder, sect); err != nil {
+					return fmt.Errorf("reading load command %d: %v", i, err)
+				}
+				b.fields(at+ofs+n, sect, int64(binary.Size(sect)))
+				n += int64(binary.Size(sect))
+			}
+		}
+		ofs += int64(len(raw))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package objfile_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+// testdata/hello.macho is gcc-amd64-darwin-exec from the Go distribution's debug/macho
+// testdata, built there with gcc on amd64 macOS from the "hello, world" program copied here as
+// testdata/hellomacho.c.  Since building it again needs a Mac, it's regenerated from a Go
+// installation, where recent releases keep it base64-encoded:
+//
+//	base64 -d "$(go env GOROOT)/src/debug/macho/testdata/gcc-amd64-darwin-exec.base64" > hello.macho
+
+func TestMachO(t *testing.T) {
+	f, err := os.Open("testdata/hello.macho")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	out, err := objfile.MachO(f, objfile.MachOOptions{Headers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int64{
+		"mach_header": 0, "load_command0": 0x20, "load_command1": 0x68,
+		"__TEXT.__text": 0xF14, "start": 0xF14, "__TEXT.__cstring": 0xFA8, "__DATA.__data": 0x1000,
+	} {
+		if ofs, ok := out.Labels.Get(name); !ok || ofs != want {
+			t.Errorf("label %s should be at 0x%X, got 0x%X, %v", name, want, ofs, ok)
+		}
+	}
+	for ofs, want := range map[int64]string{
+		0x4:  "Cpu: 0x1000007 (CpuAmd64)",
+		0x20: "Cmd: 0x19 (LoadCmdSegment64)",
+		0x28: `Name: "__PAGEZERO"`,
+	} {
+		if c := strings.Join(out.Comments.Get(ofs), "\n"); !strings.Contains(c, want) {
+			t.Errorf("comments at 0x%X should include %q, got %q", ofs, want, c)
+		}
+	}
+
+	out, err = objfile.MachO(f, objfile.MachOOptions{Segments: true, VirtualAddresses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Segments) != 3 || out.Segments[0].Offset != 0x100000000 || out.Segments[2].End() != 0x100002140 {
+		t.Fatalf("segments should be dumped at their addresses, got %d segments", len(out.Segments))
+	}
+	for name, want := range map[string]int64{"__TEXT": 0x100000000, "__DATA": 0x100001000, "_main": 0x100000F6A} {
+		if ofs, ok := out.Labels.Get(name); !ok || ofs != want {
+			t.Errorf("label %s should be at 0x%X, got 0x%X, %v", name, want, ofs, ok)
+		}
+	}
+
+	out, err = objfile.MachO(f, objfile.MachOOptions{Sections: []string{"__TEXT.__cstring"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Segments) != 1 || string(out.Segments[0].Data) != "hello, world\x00" {
+		t.Errorf("only __cstring should be dumped, got %v", out.Segments)
+	}
+}
@@ -0,0 +1,148 @@
+// Package objfile converts executable and object files to and from lhex, so
+// that their contents can be examined as annotated hex dumps.
+//
+// Front ends for ELF, PE and Mach-O files dump the sections or segments of a
+// file as lhex segments, placed at their file offsets or their addresses in
+// memory, with labels for section starts and symbols, and comments describing
+// them.  The PE and Mach-O front ends can also dump a file's headers, with a
+// comment describing each field.
+//
+// In the other direction, WriteELF turns the data in an lhex file into an ELF
+// file with a loadable segment for each run of data and a symbol for each
+// label, so that annotated memory snapshots can be used with standard tools.
+package objfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/dnesting/lhex"
+)
+
+// builder accumulates the contents of an lhex.File.
+type builder struct {
+	segs     []lhex.Segment
+	labels   map[string]int64
+	comments lhex.Comments
+}
+
+func newBuilder() *builder {
+	return &builder{labels: make(map[string]int64)}
+}
+
+// data adds a segment holding data at ofs.
+func (b *builder) data(ofs int64, data []byte) {
+	if len(data) > 0 {
+		b.segs = append(b.segs, lhex.Segment{Offset: ofs, Data: data})
+	}
+}
+
+// contains reports whether ofs lies within, or immediately follows, any data added so far.
+func (b *builder) contains(ofs int64) bool {
+	for _, s := range b.segs {
+		if ofs >= s.Offset && ofs <= s.End() {
+			return true
+		}
+	}
+	return false
+}
+
+// label adds a label at ofs named after name, made into a valid label name.  If a different
+// label already has that name, a numeric suffix is added to make it unique.  Returns the name
+// used.
+func (b *builder) label(name string, ofs int64) string {
+	base := lhex.LabelName(name)
+	name = base
+	for i := 2; ; i++ {
+		if o, ok := b.labels[name]; !ok || o == ofs {
+			break
+		}
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+	b.labels[name] = ofs
+	return name
+}
+
+// comment adds a comment at ofs.
+func (b *builder) comment(ofs int64, format string, args ...interface{}) {
+	b.comments.Add(ofs, fmt.Sprintf(format, args...))
+}
+
+// fields adds a comment at ofs for each field of the struct v, which is laid out there, giving
+// the field's name and value.  Fields not entirely within the first size bytes are skipped.
+func (b *builder) fields(ofs int64, v interface{}, size int64) {
+	b.field(ofs, "", reflect.Indirect(reflect.ValueOf(v)), size)
+}
+
+// field adds comments for v, named name, at ofs and returns its size.
+func (b *builder) field(ofs int64, name string, v reflect.Value, size int64) int64 {
+	n := int64(binary.Size(v.Interface()))
+	if n > size {
+		return n
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		var pos int64
+		for i := 0; i < v.NumField(); i++ {
+			fname := v.Type().Field(i).Name
+			if name != "" {
+				fname = name + "." + fname
+			}
+			pos += b.field(ofs+pos, fname, v.Field(i), size-pos)
+		}
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		s := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(s), v)
+		b.comment(ofs, "%s: %q", name, bytes.TrimRight(s, "\x00"))
+	case v.Kind() == reflect.Array:
+		var pos int64
+		for i := 0; i < v.Len(); i++ {
+			pos += b.field(ofs+pos, fmt.Sprintf("%s[%d]", name, i), v.Index(i), size-pos)
+		}
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		b.comment(ofs, "%s: %d", name, v.Int())
+	default:
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			b.comment(ofs, "%s: 0x%X (%s)", name, v.Uint(), s)
+		} else {
+			b.comment(ofs, "%s: 0x%X", name, v.Uint())
+		}
+	}
+	return n
+}
+
+// readStruct reads the fixed-size value v from r at ofs.
+func readStruct(r io.ReaderAt, ofs int64, order binary.ByteOrder, v interface{}) error {
+	return binary.Read(io.NewSectionReader(r, ofs, int64(binary.Size(v))), order, v)
+}
+
+// readData reads n bytes from r at ofs.
+func readData(r io.ReaderAt, ofs, n int64) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := r.ReadAt(data, ofs); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// file returns the File built so far, with its segments ordered by offset.  Where segments
+// overlap, the bytes of the earlier one are kept.
+func (b *builder) file() *lhex.File {
+	sort.SliceStable(b.segs, func(i, j int) bool { return b.segs[i].Offset < b.segs[j].Offset })
+	var segs []lhex.Segment
+	for _, s := range b.segs {
+		if n := len(segs); n > 0 && s.Offset < segs[n-1].End() {
+			end := segs[n-1].End()
+			if s.End() <= end {
+				continue
+			}
+			s = lhex.Segment{Offset: end, Data: s.Data[end-s.Offset:]}
+		}
+		segs = append(segs, s)
+	}
+	return &lhex.File{Segments: segs, Labels: lhex.NewLabels(b.labels), Comments: &b.comments}
+}
@@ -0,0 +1,312 @@
+package objfile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dnesting/lhex"
+)
+
+// PEOptions controls how PE converts a PE image or COFF object file.
+type PEOptions struct {
+	// Sections lists the names of the sections to dump.  If empty, every section with
+	// contents in the file is dumped.
+	Sections []string
+
+	// VirtualAddresses places data at its address in memory, the image base plus its
+	// relative virtual address, rather than its offset in the file.  Object files have no
+	// such addresses.
+	VirtualAddresses bool
+
+	// Headers dumps the file's headers and section table, with comments describing each of
+	// their fields.
+	Headers bool
+}
+
+// exportDirectory is the layout of a PE export directory table.
+type exportDirectory struct {
+	Characteristics       uint32
+	TimeDateStamp         uint32
+	MajorVersion          uint16
+	MinorVersion          uint16
+	Name                  uint32
+	Base                  uint32
+	NumberOfFunctions     uint32
+	NumberOfNames         uint32
+	AddressOfFunctions    uint32
+	AddressOfNames        uint32
+	AddressOfNameOrdinals uint32
+}
+
+// peImage holds what PE needs to know about a file to place its contents.
+type peImage struct {
+	f             *pe.File
+	r             io.ReaderAt
+	image         bool
+	base          uint64
+	sizeOfHeaders uint32
+	dirs          []pe.DataDirectory
+	vaddr         bool
+}
+
+// PE returns the contents of the PE image or COFF object file in r as an lhex.File.  Each
+// section dumped gets a label named after it, as does each symbol and export within the dumped
+// data, with comments describing them.
+func PE(r io.ReaderAt, opts PEOptions) (*lhex.File, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	img := &peImage{f: f, r: r, vaddr: opts.VirtualAddresses}
+	var ndirs uint32
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		img.image, img.base, img.sizeOfHeaders = true, uint64(oh.ImageBase), oh.SizeOfHeaders
+		img.dirs, ndirs = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	case *pe.OptionalHeader64:
+		img.image, img.base, img.sizeOfHeaders = true, oh.ImageBase, oh.SizeOfHeaders
+		img.dirs, ndirs = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	}
+	if ndirs < uint32(len(img.dirs)) {
+		img.dirs = img.dirs[:ndirs]
+	}
+	if opts.VirtualAddresses && !img.image {
+		return nil, errors.New("object files have no virtual addresses")
+	}
+	b := newBuilder()
+
+	if opts.Headers {
+		if err := img.headers(b); err != nil {
+			return nil, err
+		}
+	}
+
+	want := make(map[string]bool)
+	for _, name := range opts.Sections {
+		if f.Section(name) == nil {
+			return nil, fmt.Errorf("no section %q", name)
+		}
+		want[name] = true
+	}
+	for _, s := range f.Sections {
+		if s.Size == 0 || s.Offset == 0 || len(want) > 0 && !want[s.Name] {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("reading section %s: %v", s.Name, err)
+		}
+		if opts.VirtualAddresses && s.VirtualSize > 0 && int(s.VirtualSize) < len(data) {
+			data = data[:s.VirtualSize]
+		}
+		ofs, _ := img.section(s, 0)
+		b.data(ofs, data)
+	}
+
+	for _, s := range f.Sections {
+		if ofs, ok := img.section(s, 0); ok && b.contains(ofs) {
+			name := b.label(s.Name, ofs)
+			b.comment(ofs, "section %s: vaddr 0x%X, vsize 0x%X, offset 0x%X, size 0x%X, characteristics 0x%X",
+				name, s.VirtualAddress, s.VirtualSize, s.Offset, s.Size, s.Characteristics)
+		}
+	}
+
+	for _, sym := range f.Symbols {
+		if sym.SectionNumber <= 0 || int(sym.SectionNumber) > len(f.Sections) {
+			continue
+		}
+		s := f.Sections[sym.SectionNumber-1]
+		if sym.StorageClass == 3 && sym.Value == 0 && sym.Name == s.Name { // IMAGE_SYM_CLASS_STATIC
+			continue // the section's own symbol
+		}
+		ofs, ok := img.section(s, sym.Value)
+		if !ok || !b.contains(ofs) {
+			continue
+		}
+		name := b.label(sym.Name, ofs)
+		text := fmt.Sprintf("symbol %s: class %d, type 0x%X", name, sym.StorageClass, sym.Type)
+		if name != sym.Name {
+			text += fmt.Sprintf(" (%s)", sym.Name)
+		}
+		b.comment(ofs, "%s", text)
+	}
+
+	if err := img.exports(b); err != nil {
+		return nil, err
+	}
+	return b.file(), nil
+}
+
+// headers dumps and annotates the file's headers and section table.
+func (img *peImage) headers(b *builder) error {
+	var coff, end int64
+	var magic [2]byte
+	if _, err := img.r.ReadAt(magic[:], 0); err != nil {
+		return err
+	}
+	var lfanew uint32
+	if string(magic[:]) == "MZ" {
+		if err := readStruct(img.r, 0x3C, binary.LittleEndian, &lfanew); err != nil {
+			return err
+		}
+		coff = int64(lfanew) + 4
+	}
+	table := coff + int64(binary.Size(img.f.FileHeader)) + int64(img.f.SizeOfOptionalHeader)
+	end = table + int64(img.f.NumberOfSections)*int64(binary.Size(pe.SectionHeader32{}))
+	if img.image && int64(img.sizeOfHeaders) > end {
+		end = int64(img.sizeOfHeaders)
+	}
+	data, err := readData(img.r, 0, end)
+	if err != nil {
+		return fmt.Errorf("reading headers: %v", err)
+	}
+	at := func(ofs int64) int64 {
+		if img.vaddr {
+			return int64(img.base) + ofs
+		}
+		return ofs
+	}
+	b.data(at(0), data)
+
+	if coff > 0 {
+		b.label("dos_header", at(0))
+		b.comment(at(0), "e_magic: %q", magic)
+		b.comment(at(0x3C), "e_lfanew: 0x%X", lfanew)
+		b.label("pe_signature", at(coff-4))
+		b.comment(at(coff-4), "signature: %q", data[coff-4:coff])
+	}
+	b.label("file_header", at(coff))
+	b.fields(at(coff), &img.f.FileHeader, int64(binary.Size(img.f.FileHeader)))
+	if img.f.OptionalHeader != nil {
+		ofs := coff + int64(binary.Size(img.f.FileHeader))
+		b.label("optional_header", at(ofs))
+		b.fields(at(ofs), img.f.OptionalHeader, int64(img.f.SizeOfOptionalHeader))
+	}
+	if img.f.NumberOfSections > 0 {
+		b.label("section_table", at(table))
+	}
+	for i := 0; i < int(img.f.NumberOfSections); i++ {
+		var sh pe.SectionHeader32
+		ofs := table + int64(i*binary.Size(sh))
+		if err := binary.Read(bytes.NewReader(data[ofs:]), binary.LittleEndian, &sh); err != nil {
+			return err
+		}
+		b.fields(at(ofs), &sh, int64(binary.Size(sh)))
+	}
+	return nil
+}
+
+// section returns where the byte at rel within section s belongs in the output, or false if
+// it has no place there, as for uninitialized data when placing data at file offsets.
+func (img *peImage) section(s *pe.Section, rel uint32) (int64, bool) {
+	if img.vaddr {
+		return int64(img.base + uint64(s.VirtualAddress) + uint64(rel)), true
+	}
+	return int64(s.Offset) + int64(rel), s.Offset != 0 && rel < s.Size
+}
+
+// placeRVA is like section for a relative virtual address.
+func (img *peImage) placeRVA(rva uint32) (int64, bool) {
+	if s, rel, ok := img.rva(rva); ok {
+		return img.section(s, rel)
+	}
+	return 0, false
+}
+
+// rva returns the section containing the relative virtual address rva, along with rva's offset
+// within it.
+func (img *peImage) rva(rva uint32) (*pe.Section, uint32, bool) {
+	for _, s := range img.f.Sections {
+		size := s.VirtualSize
+		if size < s.Size {
+			size = s.Size
+		}
+		if rva >= s.VirtualAddress && rva-s.VirtualAddress < size {
+			return s, rva - s.VirtualAddress, true
+		}
+	}
+	return nil, 0, false
+}
+
+// readRVA reads the fixed-size value v from rva.
+func (img *peImage) readRVA(rva uint32, v interface{}) error {
+	s, rel, ok := img.rva(rva)
+	if !ok {
+		return fmt.Errorf("address 0x%X not in any section", rva)
+	}
+	return readStruct(s, int64(rel), binary.LittleEndian, v)
+}
+
+// exports labels each exported function or variable found within the dumped data, and
+// annotates the export directory if it was dumped.
+func (img *peImage) exports(b *builder) error {
+	if len(img.dirs) <= pe.IMAGE_DIRECTORY_ENTRY_EXPORT || img.dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT].Size == 0 {
+		return nil
+	}
+	dir := img.dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT]
+	var ed exportDirectory
+	if err := img.readRVA(dir.VirtualAddress, &ed); err != nil {
+		return fmt.Errorf("reading export directory: %v", err)
+	}
+	if ofs, ok := img.placeRVA(dir.VirtualAddress); ok && b.contains(ofs) {
+		b.label("export_directory", ofs)
+		b.fields(ofs, &ed, int64(binary.Size(ed)))
+	}
+
+	for i := uint32(0); i < ed.NumberOfNames; i++ {
+		var nameRVA, fn uint32
+		var ordinal uint16
+		if err := img.readRVA(ed.AddressOfNames+4*i, &nameRVA); err != nil {
+			return fmt.Errorf("reading export names: %v", err)
+		}
+		if err := img.readRVA(ed.AddressOfNameOrdinals+2*i, &ordinal); err != nil {
+			return fmt.Errorf("reading export ordinals: %v", err)
+		}
+		if err := img.readRVA(ed.AddressOfFunctions+4*uint32(ordinal), &fn); err != nil {
+			return fmt.Errorf("reading export addresses: %v", err)
+		}
+		name, err := img.cstring(nameRVA)
+		if err != nil {
+			return fmt.Errorf("reading export name: %v", err)
+		}
+		if fn >= dir.VirtualAddress && fn-dir.VirtualAddress < dir.Size {
+			continue // forwarded to another DLL
+		}
+		ofs, ok := img.placeRVA(fn)
+		if !ok || !b.contains(ofs) {
+			continue
+		}
+		label := b.label(name, ofs)
+		text := fmt.Sprintf("export %s: ordinal %d, rva 0x%X", label, ed.Base+uint32(ordinal), fn)
+		if label != name {
+			text += fmt.Sprintf(" (%s)", name)
+		}
+		b.comment(ofs, "%s", text)
+	}
+	return nil
+}
+
+// cstring reads the NUL-terminated string at rva.
+func (img *peImage) cstring(rva uint32) (string, error) {
+	var name []byte
+	buf := make([]byte, 64)
+	for {
+		s, rel, ok := img.rva(rva)
+		if !ok {
+			return "", fmt.Errorf("address 0x%X not in any section", rva)
+		}
+		n, err := s.ReadAt(buf, int64(rel))
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(name, buf[:i]...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name = append(name, buf[:n]...)
+		rva += uint32(n)
+	}
+}
@@ -0,0 +1,67 @@
+package objfile_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex/objfile"
+)
+
+// testdata/hello.dll is built from testdata/hellodll.c with:
+//
+//	gcc -Os -c -fno-asynchronous-unwind-tables -fno-stack-protector -o hellodll.o hellodll.c
+//	objcopy -O pe-x86-64 --remove-section=.note.GNU-stack --remove-section=.comment \
+//	    --remove-section=.note.gnu.property hellodll.o hellodll.obj
+//	ld -m i386pep --dll -e 0 --export-all-symbols --no-insert-timestamp \
+//	    --file-alignment 0x200 --section-alignment 0x1000 -o hello.dll hellodll.obj
+
+func TestPE(t *testing.T) {
+	f, err := os.Open("testdata/hello.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	out, err := objfile.PE(f, objfile.PEOptions{Headers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int64{
+		"dos_header": 0, "pe_signature": 0x80, "file_header": 0x84, "optional_header": 0x98,
+		".text": 0x400, "add": 0x400, "twice": 0x404, "counter": 0x600, "greeting": 0x800,
+		"export_directory": 0xA00,
+	} {
+		if ofs, ok := out.Labels.Get(name); !ok || ofs != want {
+			t.Errorf("label %s should be at 0x%X, got 0x%X, %v", name, want, ofs, ok)
+		}
+	}
+	for ofs, want := range map[int64]string{
+		0x84:  "Machine: 0x8664",
+		0xB0:  "ImageBase: 0x180000000",
+		0x108: "DataDirectory[0].VirtualAddress: 0x4000",
+		0x404: "export twice: ordinal 4, rva 0x1004",
+	} {
+		if c := strings.Join(out.Comments.Get(ofs), "\n"); !strings.Contains(c, want) {
+			t.Errorf("comments at 0x%X should include %q, got %q", ofs, want, c)
+		}
+	}
+
+	out, err = objfile.PE(f, objfile.PEOptions{Sections: []string{".rodata"}, VirtualAddresses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Segments) != 1 || out.Segments[0].Offset != 0x180003000 || string(out.Segments[0].Data) != "hello, world\x00\x00\x00\x00" {
+		t.Fatalf(".rodata should be dumped at its address, got %v", out.Segments)
+	}
+	if ofs, _ := out.Labels.Get("greeting"); ofs != 0x180003000 {
+		t.Errorf("greeting should be at its address, got 0x%X", ofs)
+	}
+	if _, ok := out.Labels.Get("add"); ok {
+		t.Errorf("symbols outside the dumped sections should not be labelled")
+	}
+
+	if _, err := objfile.PE(f, objfile.PEOptions{Sections: []string{".nope"}}); err == nil {
+		t.Errorf("asking for a missing section should fail")
+	}
+}
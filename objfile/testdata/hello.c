@@ -0,0 +1,4 @@
+const char greeting[] = "hello, world";
+int counter = 42;
+int add(int a, int b) { return a + b + counter; }
+int _start(void) { return add(1, 2); }
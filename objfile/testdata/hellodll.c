@@ -0,0 +1,6 @@
+int counter = 42;
+const char greeting[] = "hello, world";
+
+int add(int a, int b) { return a + b; }
+
+int twice(int a) { return a * 2; }
@@ -0,0 +1,88 @@
+package lhex
+
+import (
+	"fmt"
+	"io"
+)
+
+// MismatchError is returned by ApplyExpect when the destination does not contain the expected
+// bytes.
+type MismatchError struct {
+	Offset int64  // offset of the first byte that differs
+	Want   []byte // expected bytes starting at Offset
+	Got    []byte // actual bytes starting at Offset, which may be shorter than Want at EOF
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("expected bytes at 0x%X do not match: want % X, got % X", e.Offset, e.Want, e.Got)
+}
+
+// Apply writes each segment of patch to w at its offset.  Bytes not described by patch are
+// left untouched, as are wildcard bits, which requires that w also be an io.ReaderAt if patch
+// has any.  Wildcard bits past the end of w's data are written as 0.
+func Apply(w io.WriterAt, patch *File) error {
+	for _, s := range patch.Segments {
+		data := s.Data
+		if s.Mask != nil {
+			r, ok := w.(io.ReaderAt)
+			if !ok {
+				return fmt.Errorf("patch has wildcards at 0x%X but the destination can't be read", s.Offset)
+			}
+			data = make([]byte, len(s.Data))
+			if _, err := r.ReadAt(data, s.Offset); err != nil && err != io.EOF {
+				return err
+			}
+			for i := range data {
+				data[i] = data[i]&^s.Mask[i] | s.Data[i]&s.Mask[i]
+			}
+		}
+		if _, err := w.WriteAt(data, s.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyExpect is like Apply, but first verifies that rw contains the bytes described by expect,
+// which would typically describe the original contents of the regions patch overwrites.  If any
+// of these bytes differ, nothing is written and a *MismatchError is returned.
+func ApplyExpect(rw interface {
+	io.ReaderAt
+	io.WriterAt
+}, patch, expect *File) error {
+	if err := Verify(rw, expect); err != nil {
+		return err
+	}
+	return Apply(rw, patch)
+}
+
+// Verify checks that r contains the bytes described by each segment of expect, returning a
+// *MismatchError describing the first difference if it does not.  Wildcard bits in expect
+// match anything.
+func Verify(r io.ReaderAt, expect *File) error {
+	for _, s := range expect.Segments {
+		got := make([]byte, len(s.Data))
+		n, err := r.ReadAt(got, s.Offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		got = got[:n]
+		i := 0
+		for i < len(got) && matches(got[i], s.Data[i], maskAt(s.Mask, i)) {
+			i++
+		}
+		if i == len(s.Data) {
+			continue
+		}
+		end := i + 16
+		if end > len(s.Data) {
+			end = len(s.Data)
+		}
+		gotEnd := end
+		if gotEnd > len(got) {
+			gotEnd = len(got)
+		}
+		return &MismatchError{Offset: s.Offset + int64(i), Want: s.Data[i:end], Got: got[i:gotEnd]}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package lhex_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestApply(t *testing.T) {
+	f, err := ioutil.TempFile("", "lhex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("Hello, world!\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := lhex.Decode(strings.NewReader(`
+00000007  74 68 65 72 65                                    |there|
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, _ := lhex.Decode(strings.NewReader(`
+00000007  6D 6F 6F 6E                                       |moon|
+`))
+	right, _ := lhex.Decode(strings.NewReader(`
+00000007  77 6F 72 6C 64                                    |world|
+`))
+
+	err = lhex.ApplyExpect(f, patch, wrong)
+	if me, ok := err.(*lhex.MismatchError); !ok || me.Offset != 7 || string(me.Want) != "moon" || string(me.Got) != "worl" {
+		t.Errorf("ApplyExpect should fail at 0x7 with want=moon got=worl, got %v", err)
+	}
+	if err := lhex.ApplyExpect(f, patch, right); err != nil {
+		t.Errorf("ApplyExpect should succeed, got %v", err)
+	}
+
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello, there!\n" {
+		t.Errorf("patched file should read %q, got %q", "Hello, there!\n", got)
+	}
+
+	if err := lhex.Verify(f, right); err == nil {
+		t.Errorf("Verify should fail against the already-patched file")
+	}
+}
+
+func TestVerifyWildcards(t *testing.T) {
+	expect, err := lhex.Decode(strings.NewReader("00000007  77 ?? 72 6?\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lhex.Verify(strings.NewReader("Hello, world!\n"), expect); err != nil {
+		t.Errorf("Verify should ignore wildcard bits, got %v", err)
+	}
+	if err := lhex.Verify(strings.NewReader("Hello, wo!ld!\n"), expect); err == nil {
+		t.Errorf("Verify should still check known bytes")
+	}
+}
+
+func TestApplyWildcards(t *testing.T) {
+	f, err := ioutil.TempFile("", "lhex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("Hello, world!\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set the low nybble of 'w', making it 's', and leave the 'o' alone.
+	patch, err := lhex.Decode(strings.NewReader("00000007  ?3 ??\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lhex.Apply(f, patch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello, sorld!\n" {
+		t.Errorf("patched file should read %q, got %q", "Hello, sorld!\n", got)
+	}
+
+	if err := lhex.Apply(onlyWriterAt{f}, patch); err == nil {
+		t.Errorf("Apply should refuse wildcards it can't preserve")
+	}
+}
+
+// onlyWriterAt hides any methods but WriteAt.
+type onlyWriterAt struct {
+	w io.WriterAt
+}
+
+func (o onlyWriterAt) WriteAt(p []byte, off int64) (int, error) { return o.w.WriteAt(p, off) }
@@ -0,0 +1,106 @@
+// Package pcap converts packet captures in the pcap and pcapng formats to
+// lhex, so that packets can be annotated and compared as hex dumps.
+//
+// Decode places each packet in its own segment, labelled "packet1",
+// "packet2" and so on, with a comment giving its timestamp, interface and
+// length.  With Options.Align set to 16, a capture might look like:
+//
+//	# interface 0: ETHERNET, snaplen 262144
+//	# packet 1: time 2024-05-01T12:00:00.000123Z, interface 0, length 42
+//	:packet1
+//	00000000  FF FF FF FF FF FF 00 11  22 33 44 55 08 06 00 01  |........"3DU....|
+//	00000010  08 00 06 04 00 01 00 11  22 33 44 55 0A 00 00 01  |........"3DU....|
+//	00000020  00 00 00 00 00 00 0A 00  00 02                    |..........|
+//
+//	# packet 2: time 2024-05-01T12:00:01.000123Z, interface 0, length 20
+//	:packet2
+//	00000030  78 78 78 78 78 78 78 78  78 78 78 78 78 78 78 78  |xxxxxxxxxxxxxxxx|
+//	00000040  78 78 78 78                                       |xxxx|
+//
+// Encode goes the other way, writing the segments of an lhex file as packets
+// in a pcapng or pcap capture, with timestamps taken from comments like those
+// above and labels kept as packet comments.  Reader and Writer can also be
+// used directly to read and write packets.
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dnesting/lhex"
+)
+
+// Options controls how Decode lays out packets.
+type Options struct {
+	// Align, if positive, packs packets one after another, each starting at the next
+	// multiple of Align, rather than placing them at their offsets in the capture.
+	Align int64
+}
+
+// Decode reads the capture in r and returns its packets as an lhex.File.  Each packet becomes
+// a segment with a label at its start, and comments describing it and any interfaces described
+// ahead of it.  Comments attached to packets in a pcapng capture are included as well.
+func Decode(r io.Reader, opts Options) (*lhex.File, error) {
+	pr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &lhex.File{Comments: &lhex.Comments{}}
+	labels := make(map[string]int64)
+	var described int
+	var end int64
+	describe := func(ofs int64) {
+		for ifaces := pr.Interfaces(); described < len(ifaces); described++ {
+			f.Comments.Add(ofs, interfaceComment(described, ifaces[described]))
+		}
+	}
+	for n := 1; ; n++ {
+		p, err := pr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ofs := p.Offset
+		if opts.Align > 0 {
+			ofs = (end + opts.Align - 1) / opts.Align * opts.Align
+		}
+		end = ofs + int64(len(p.Data))
+
+		describe(ofs)
+		labels[fmt.Sprintf("packet%d", n)] = ofs
+		f.Comments.Add(ofs, packetComment(n, p))
+		for _, c := range p.Comments {
+			f.Comments.Add(ofs, "comment: "+c)
+		}
+		if len(p.Data) > 0 {
+			f.Segments = append(f.Segments, lhex.Segment{Offset: ofs, Data: p.Data})
+		}
+	}
+	describe(end)
+	f.Labels = lhex.NewLabels(labels)
+	return f, nil
+}
+
+// interfaceComment describes interface i.
+func interfaceComment(i int, iface Interface) string {
+	s := fmt.Sprintf("interface %d: %s, snaplen %d", i, iface.LinkType, iface.SnapLen)
+	if iface.Name != "" {
+		s += ", name " + iface.Name
+	}
+	return s
+}
+
+// packetComment describes p, the nth packet.
+func packetComment(n int, p *Packet) string {
+	s := fmt.Sprintf("packet %d: ", n)
+	if !p.Time.IsZero() {
+		s += fmt.Sprintf("time %s, ", p.Time.Format(time.RFC3339Nano))
+	}
+	s += fmt.Sprintf("interface %d, length %d", p.Interface, p.Length)
+	if len(p.Data) < p.Length {
+		s += fmt.Sprintf(", captured %d", len(p.Data))
+	}
+	return s
+}
@@ -0,0 +1,265 @@
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnesting/lhex"
+	"github.com/dnesting/lhex/pcap"
+)
+
+// classic returns a little-endian pcap capture of packets with microsecond timestamps.
+func classic(linkType uint32, packets ...[]byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, []uint32{0xA1B2C3D4, 2 | 4<<16, 0, 0, 65535, linkType})
+	for i, p := range packets {
+		binary.Write(&buf, le, []uint32{1700000000 + uint32(i), 250, uint32(len(p)), uint32(len(p))})
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+// block returns a pcapng block of type typ with the given body, which must be padded.
+func block(order binary.ByteOrder, typ uint32, body []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, order, []uint32{typ, uint32(len(body) + 12)})
+	buf.Write(body)
+	binary.Write(&buf, order, uint32(len(body)+12))
+	return buf.Bytes()
+}
+
+// option returns a pcapng option, padded.
+func option(order binary.ByteOrder, code uint16, val string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, order, []uint16{code, uint16(len(val))})
+	buf.WriteString(val)
+	buf.Write(make([]byte, (4-len(val)%4)%4))
+	return buf.Bytes()
+}
+
+// ng returns a pcapng capture with one Ethernet interface with nanosecond timestamps, and an
+// enhanced packet block for each packet, the first with a comment.
+func ng(order binary.ByteOrder, packets ...[]byte) []byte {
+	var buf, body bytes.Buffer
+	binary.Write(&body, order, []uint32{0x1A2B3C4D, 1, 0xFFFFFFFF, 0xFFFFFFFF})
+	buf.Write(block(order, 0x0A0D0D0A, body.Bytes()))
+
+	body.Reset()
+	binary.Write(&body, order, []uint16{1, 0})
+	binary.Write(&body, order, uint32(1500))
+	body.Write(option(order, 2, "eth0"))
+	body.Write(option(order, 9, "\x09"))
+	body.Write(option(order, 0, ""))
+	buf.Write(block(order, 1, body.Bytes()))
+
+	for i, p := range packets {
+		body.Reset()
+		ts := uint64(1700000000+i)*1e9 + 5
+		binary.Write(&body, order, []uint32{0, uint32(ts >> 32), uint32(ts), uint32(len(p)), uint32(len(p) + 10)})
+		body.Write(p)
+		body.Write(make([]byte, (4-len(p)%4)%4))
+		if i == 0 {
+			body.Write(option(order, 1, "first!"))
+			body.Write(option(order, 0, ""))
+		}
+		buf.Write(block(order, 6, body.Bytes()))
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		capture []byte
+		ifname  string
+		time    time.Time
+		length  int
+	}{
+		{"pcap", classic(1, []byte("abc"), []byte("defgh")), "", time.Unix(1700000000, 250000).UTC(), 3},
+		{"pcapng-le", ng(binary.LittleEndian, []byte("abc"), []byte("defgh")), "eth0", time.Unix(1700000000, 5).UTC(), 13},
+		{"pcapng-be", ng(binary.BigEndian, []byte("abc"), []byte("defgh")), "eth0", time.Unix(1700000000, 5).UTC(), 13},
+	} {
+		r, err := pcap.NewReader(bytes.NewReader(tc.capture))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		p, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if string(p.Data) != "abc" || p.Length != tc.length || !p.Time.Equal(tc.time) {
+			t.Errorf("%s: first packet should be abc at %v, length %d, got %q at %v, length %d", tc.name, tc.time, tc.length, p.Data, p.Time, p.Length)
+		}
+		if got := string(tc.capture[p.Offset : p.Offset+3]); got != "abc" {
+			t.Errorf("%s: packet offset should locate its data, got %q", tc.name, got)
+		}
+		if ifaces := r.Interfaces(); len(ifaces) != 1 || ifaces[0].LinkType != pcap.LinkTypeEthernet || ifaces[0].Name != tc.ifname {
+			t.Errorf("%s: should have one Ethernet interface named %q, got %+v", tc.name, tc.ifname, ifaces)
+		}
+		if p, err = r.Next(); err != nil || string(p.Data) != "defgh" {
+			t.Errorf("%s: second packet should be defgh, got %v, %v", tc.name, p, err)
+		}
+		if _, err = r.Next(); err == nil || err.Error() != "EOF" {
+			t.Errorf("%s: should get EOF after the last packet, got %v", tc.name, err)
+		}
+	}
+
+	if _, err := pcap.NewReader(strings.NewReader("not a capture at all")); err == nil {
+		t.Errorf("reading something other than a capture should fail")
+	}
+	r, err := pcap.NewReader(bytes.NewReader(classic(1, []byte("abc"))[:42]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Errorf("reading a truncated packet should fail")
+	}
+
+	// Lengths in the capture shouldn't be trusted before the data is there.
+	long := classic(1, []byte("abc"))
+	binary.LittleEndian.PutUint32(long[24+8:], 70000)
+	if r, err = pcap.NewReader(bytes.NewReader(long)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("a packet longer than the snapshot length should fail, got %v", err)
+	}
+	long = ng(binary.LittleEndian, []byte("abc"))
+	binary.LittleEndian.PutUint32(long[len(ng(binary.LittleEndian))+4:], 0xFFFFFFF0)
+	if r, err = pcap.NewReader(bytes.NewReader(long)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("a block longer than the capture should fail, got %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	f, err := pcap.Decode(bytes.NewReader(ng(binary.LittleEndian, []byte("abc"), []byte("defgh"))), pcap.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Segments) != 2 || string(f.Segments[0].Data) != "abc" || string(f.Segments[1].Data) != "defgh" {
+		t.Fatalf("each packet should be a segment, got %v", f.Segments)
+	}
+	ofs, ok := f.Labels.Get("packet1")
+	if !ok || ofs != f.Segments[0].Offset {
+		t.Errorf("packet1 should label the first packet, got 0x%X, %v", ofs, ok)
+	}
+	want := []string{
+		"interface 0: ETHERNET, snaplen 1500, name eth0",
+		"packet 1: time 2023-11-14T22:13:20.000000005Z, interface 0, length 13, captured 3",
+		"comment: first!",
+	}
+	if got := f.Comments.Get(ofs); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("first packet comments should be %q, got %q", want, got)
+	}
+
+	f, err = pcap.Decode(bytes.NewReader(classic(1, []byte("abc"), []byte("defgh"), []byte("ij"))), pcap.Options{Align: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int64{0, 4, 12} {
+		if f.Segments[i].Offset != want {
+			t.Errorf("aligned packet %d should be at %d, got %d", i+1, want, f.Segments[i].Offset)
+		}
+	}
+	if ofs, _ := f.Labels.Get("packet3"); ofs != 12 {
+		t.Errorf("packet3 should be at 12, got %d", ofs)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	in, err := lhex.Decode(strings.NewReader(`
+# interface 0: RAW, snaplen 0
+# packet 1: time 2024-05-01T12:00:00.5Z, interface 0, length 100
+:hello
+00000000  68 65 6C 6C 6F
+:world
+00000005  77 6F 72 6C 64
+# just a note
+# comment: from the capture
+:gap
+00000010  41 42 43
+# packet 2: time 2024-05-01T12:00:02Z
+:packet2
+00000013  44 45
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pcap.Encode(&buf, in, pcap.WriteOptions{Interval: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcap.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		data     string
+		time     time.Time
+		length   int
+		comments []string
+	}{
+		{"helloworld", time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC), 100, []string{"hello", "world at +0x5"}},
+		{"ABC", time.Date(2024, 5, 1, 12, 0, 1, 5e8, time.UTC), 3, []string{"from the capture", "gap"}},
+		{"DE", time.Date(2024, 5, 1, 12, 0, 2, 0, time.UTC), 2, nil},
+	} {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i+1, err)
+		}
+		if string(p.Data) != want.data || !p.Time.Equal(want.time) || p.Length != want.length ||
+			strings.Join(p.Comments, "|") != strings.Join(want.comments, "|") {
+			t.Errorf("packet %d should be %+v, got %+v", i+1, want, p)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("should have 3 packets, got %v", err)
+	}
+	if ifaces := r.Interfaces(); ifaces[0].LinkType != pcap.LinkTypeRaw {
+		t.Errorf("link type should come from the interface comment, got %v", ifaces[0].LinkType)
+	}
+
+	// A link type that's given wins over the comment, even if it's LinkTypeNull.
+	buf.Reset()
+	if err := pcap.Encode(&buf, in, pcap.WriteOptions{LinkType: pcap.LinkTypeNull, HasLinkType: true}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = pcap.NewReader(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if ifaces := r.Interfaces(); ifaces[0].LinkType != pcap.LinkTypeNull {
+		t.Errorf("link type should be NULL as given, got %v", ifaces[0].LinkType)
+	}
+
+	// A pcap capture should decode back to the same packets and comments.
+	orig := classic(1, []byte("abc"), []byte("defgh"))
+	f, err := pcap.Decode(bytes.NewReader(orig), pcap.Options{Align: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := pcap.Encode(&buf, f, pcap.WriteOptions{Classic: true, SnapLen: 65535}); err != nil {
+		t.Fatal(err)
+	}
+	back, err := pcap.Decode(&buf, pcap.Options{Align: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got bytes.Buffer
+	f.WriteTo(&want)
+	back.WriteTo(&got)
+	if want.String() != got.String() {
+		t.Errorf("capture should survive a round trip, want:\n%s\ngot:\n%s", want.String(), got.String())
+	}
+}
@@ -0,0 +1,420 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"time"
+)
+
+// Magic numbers identifying capture formats.
+const (
+	magicMicros = 0xA1B2C3D4 // pcap with microsecond timestamps
+	magicNanos  = 0xA1B23C4D // pcap with nanosecond timestamps
+	blockSHB    = 0x0A0D0D0A // pcapng section header block
+	byteOrderNG = 0x1A2B3C4D // pcapng byte-order magic
+)
+
+// pcapng block types.
+const (
+	blockIDB = 1 // interface description
+	blockPB  = 2 // packet (obsolete)
+	blockSPB = 3 // simple packet
+	blockEPB = 6 // enhanced packet
+)
+
+// pcapng option codes.
+const (
+	optEnd      = 0
+	optComment  = 1
+	optIfName   = 2
+	optTSResol  = 9
+	optTSOffset = 14
+)
+
+// maxCapLen is the most data a pcap record may hold when its interface doesn't give a smaller
+// snapshot length, as in libpcap.
+const maxCapLen = 256 << 10
+
+// LinkType identifies the kind of link a packet was captured from, like LINKTYPE_ETHERNET.
+type LinkType uint32
+
+// Some common link types.
+const (
+	LinkTypeNull      LinkType = 0
+	LinkTypeEthernet  LinkType = 1
+	LinkTypeRaw       LinkType = 101
+	LinkTypeIEEE80211 LinkType = 105
+	LinkTypeLinuxSLL  LinkType = 113
+	LinkTypeRadiotap  LinkType = 127
+	LinkTypeIPv4      LinkType = 228
+	LinkTypeIPv6      LinkType = 229
+	LinkTypeLinuxSLL2 LinkType = 276
+)
+
+var linkTypeNames = map[LinkType]string{
+	LinkTypeNull:      "NULL",
+	LinkTypeEthernet:  "ETHERNET",
+	LinkTypeRaw:       "RAW",
+	LinkTypeIEEE80211: "IEEE802_11",
+	LinkTypeLinuxSLL:  "LINUX_SLL",
+	LinkTypeRadiotap:  "IEEE802_11_RADIOTAP",
+	LinkTypeIPv4:      "IPV4",
+	LinkTypeIPv6:      "IPV6",
+	LinkTypeLinuxSLL2: "LINUX_SLL2",
+}
+
+func (t LinkType) String() string {
+	if name, ok := linkTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("LinkType(%d)", uint32(t))
+}
+
+// Interface describes an interface packets were captured on.  A pcap file has a single
+// interface; a pcapng file describes each of its interfaces.
+type Interface struct {
+	LinkType LinkType
+	SnapLen  uint32
+	Name     string // if known
+	Offset   int64  // where the interface was described in the capture
+
+	tsUnit   uint8 // timestamp resolution, as in the pcapng if_tsresol option
+	tsOffset int64 // seconds to add to timestamps
+}
+
+// Packet is a packet read from a capture.
+type Packet struct {
+	Offset    int64     // where Data starts in the capture
+	Time      time.Time // zero if the capture doesn't record it
+	Interface int       // index into Reader.Interfaces
+	Length    int       // length of the packet on the wire, which may exceed len(Data)
+	Data      []byte    // the bytes captured
+	Comments  []string  // comments attached to the packet in the capture
+}
+
+// Reader reads packets from a pcap or pcapng capture.
+type Reader struct {
+	r      *bufio.Reader
+	ofs    int64 // offset of the next byte from r
+	order  binary.ByteOrder
+	ng     bool
+	ifaces []Interface
+	base   int // index in ifaces of the current pcapng section's first interface
+}
+
+// NewReader returns a Reader reading the pcap or pcapng capture in r, after reading its header.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReader(r)}
+	magic, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == blockSHB {
+		pr.ng = true
+		if err := pr.readSection(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	}
+
+	var hdr [24]byte
+	if err := pr.read(hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+	tsUnit := uint8(6)
+	for _, pr.order = range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch pr.order.Uint32(hdr[:]) {
+		case magicMicros:
+		case magicNanos:
+			tsUnit = 9
+		default:
+			continue
+		}
+		pr.ifaces = append(pr.ifaces, Interface{
+			LinkType: LinkType(pr.order.Uint32(hdr[20:]) & 0x0FFFFFFF),
+			SnapLen:  pr.order.Uint32(hdr[16:]),
+			tsUnit:   tsUnit,
+		})
+		return pr, nil
+	}
+	return nil, errors.New("not a pcap or pcapng capture")
+}
+
+// Interfaces returns the interfaces described by the capture so far.  In a pcapng capture,
+// interfaces may be described anywhere, so more may appear after calls to Next.
+func (r *Reader) Interfaces() []Interface {
+	return r.ifaces
+}
+
+// read fills p from the capture.
+func (r *Reader) read(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.ofs += int64(n)
+	return err
+}
+
+// readN reads the next n bytes from the capture.  Since n comes from the capture itself, the
+// buffer grows as the data arrives rather than being allocated up front.
+func (r *Reader) readN(n int64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r.r, n))
+	r.ofs += int64(len(buf))
+	if err == nil && int64(len(buf)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+// Next returns the next packet in the capture, or io.EOF if there are no more.
+func (r *Reader) Next() (*Packet, error) {
+	if !r.ng {
+		return r.nextPcap()
+	}
+	for {
+		ofs := r.ofs
+		typ, body, err := r.block()
+		if err != nil {
+			return nil, err
+		}
+		if p, err := r.parseBlock(typ, body, ofs); p != nil || err != nil {
+			return p, err
+		}
+	}
+}
+
+// nextPcap reads the next record from a pcap capture.
+func (r *Reader) nextPcap() (*Packet, error) {
+	var hdr [16]byte
+	if err := r.read(hdr[:]); err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("truncated packet header at 0x%X", r.ofs)
+	} else if err != nil {
+		return nil, err
+	}
+	iface := &r.ifaces[0]
+	caplen, limit := r.order.Uint32(hdr[8:]), uint32(maxCapLen)
+	if iface.SnapLen != 0 && iface.SnapLen < limit {
+		limit = iface.SnapLen
+	}
+	if caplen > limit {
+		return nil, fmt.Errorf("packet at 0x%X too long, %d bytes captured with a limit of %d", r.ofs, caplen, limit)
+	}
+	sec, frac := r.order.Uint32(hdr[0:]), r.order.Uint32(hdr[4:])
+	p := &Packet{
+		Offset: r.ofs,
+		Time:   iface.time(uint64(sec)*pow10(iface.tsUnit) + uint64(frac)),
+		Length: int(r.order.Uint32(hdr[12:])),
+	}
+	var err error
+	if p.Data, err = r.readN(int64(caplen)); err != nil {
+		return nil, fmt.Errorf("truncated packet at 0x%X", p.Offset)
+	}
+	return p, nil
+}
+
+// block reads the next pcapng block, returning its type and body.  If the block is a section
+// header, it is processed and nil is returned for its body.
+func (r *Reader) block() (typ uint32, body []byte, err error) {
+	hdr, err := r.r.Peek(8)
+	if err == io.EOF && len(hdr) == 0 {
+		return 0, nil, io.EOF
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("truncated block at 0x%X", r.ofs)
+	}
+	if binary.LittleEndian.Uint32(hdr) == blockSHB {
+		return blockSHB, nil, r.readSection()
+	}
+	typ = r.order.Uint32(hdr)
+	length := r.order.Uint32(hdr[4:])
+	if length < 12 || length%4 != 0 {
+		return 0, nil, fmt.Errorf("invalid block length %d at 0x%X", length, r.ofs)
+	}
+	buf, err := r.readN(int64(length))
+	if err != nil {
+		return 0, nil, fmt.Errorf("truncated block at 0x%X", r.ofs)
+	}
+	return typ, buf[8 : length-4], nil
+}
+
+// readSection reads a pcapng section header block, which starts a new set of interfaces.
+func (r *Reader) readSection() error {
+	start := r.ofs
+	var hdr [12]byte
+	if err := r.read(hdr[:]); err != nil {
+		return fmt.Errorf("truncated section header at 0x%X", start)
+	}
+	switch uint32(byteOrderNG) {
+	case binary.LittleEndian.Uint32(hdr[8:]):
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[8:]):
+		r.order = binary.BigEndian
+	default:
+		return fmt.Errorf("invalid byte-order magic at 0x%X", start+8)
+	}
+	length := r.order.Uint32(hdr[4:])
+	if length < 28 || length%4 != 0 {
+		return fmt.Errorf("invalid block length %d at 0x%X", length, start)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r.r, int64(length)-12); err != nil {
+		return fmt.Errorf("truncated section header at 0x%X", start)
+	}
+	r.ofs += int64(length) - 12
+	r.base = len(r.ifaces)
+	return nil
+}
+
+// parseBlock handles a pcapng block read from ofs, returning a packet if it holds one.
+func (r *Reader) parseBlock(typ uint32, body []byte, ofs int64) (*Packet, error) {
+	truncated := fmt.Errorf("truncated block at 0x%X", ofs)
+	switch typ {
+	case blockIDB:
+		if len(body) < 8 {
+			return nil, truncated
+		}
+		iface := Interface{
+			LinkType: LinkType(r.order.Uint16(body)),
+			SnapLen:  r.order.Uint32(body[4:]),
+			Offset:   ofs,
+			tsUnit:   6,
+		}
+		err := r.options(body[8:], func(code uint16, val []byte) {
+			switch {
+			case code == optIfName:
+				iface.Name = string(val)
+			case code == optTSResol && len(val) >= 1:
+				iface.tsUnit = val[0]
+			case code == optTSOffset && len(val) >= 8:
+				iface.tsOffset = int64(r.order.Uint64(val))
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("block at 0x%X: %v", ofs, err)
+		}
+		r.ifaces = append(r.ifaces, iface)
+
+	case blockEPB, blockPB:
+		if len(body) < 20 {
+			return nil, truncated
+		}
+		var id int
+		if typ == blockEPB {
+			id = int(r.order.Uint32(body))
+		} else {
+			id = int(r.order.Uint16(body))
+		}
+		iface, err := r.iface(id, ofs)
+		if err != nil {
+			return nil, err
+		}
+		ts := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+		caplen := r.order.Uint32(body[12:])
+		if uint64(caplen) > uint64(len(body)-20) {
+			return nil, truncated
+		}
+		p := &Packet{
+			Offset:    ofs + 28,
+			Time:      r.ifaces[iface].time(ts),
+			Interface: iface,
+			Length:    int(r.order.Uint32(body[16:])),
+			Data:      body[20 : 20+caplen],
+		}
+		err = r.options(body[20+pad4(int(caplen)):], func(code uint16, val []byte) {
+			if code == optComment {
+				p.Comments = append(p.Comments, string(val))
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("block at 0x%X: %v", ofs, err)
+		}
+		return p, nil
+
+	case blockSPB:
+		if len(body) < 4 {
+			return nil, truncated
+		}
+		iface, err := r.iface(0, ofs)
+		if err != nil {
+			return nil, err
+		}
+		length := r.order.Uint32(body)
+		caplen := length
+		if snap := r.ifaces[iface].SnapLen; snap != 0 && snap < caplen {
+			caplen = snap
+		}
+		if uint64(caplen) > uint64(len(body)-4) {
+			return nil, truncated
+		}
+		return &Packet{Offset: ofs + 12, Interface: iface, Length: int(length), Data: body[4 : 4+caplen]}, nil
+	}
+	return nil, nil
+}
+
+// iface returns the index in r.ifaces of the current section's interface id.
+func (r *Reader) iface(id int, ofs int64) (int, error) {
+	if id < 0 || r.base+id >= len(r.ifaces) {
+		return 0, fmt.Errorf("block at 0x%X refers to unknown interface %d", ofs, id)
+	}
+	return r.base + id, nil
+}
+
+// options calls fn with each option in the pcapng options in buf.
+func (r *Reader) options(buf []byte, fn func(code uint16, val []byte)) error {
+	for len(buf) >= 4 {
+		code, n := r.order.Uint16(buf), int(r.order.Uint16(buf[2:]))
+		if code == optEnd {
+			break
+		}
+		if 4+n > len(buf) {
+			return errors.New("truncated option")
+		}
+		fn(code, buf[4:4+n])
+		if n = 4 + pad4(n); n > len(buf) {
+			n = len(buf)
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// pad4 returns n rounded up to a multiple of 4.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// pow10 returns 10**n.
+func pow10(n uint8) uint64 {
+	v := uint64(1)
+	for ; n > 0; n-- {
+		v *= 10
+	}
+	return v
+}
+
+// time returns the time of a timestamp ts in units of the interface's resolution.
+func (iface *Interface) time(ts uint64) time.Time {
+	var sec, nsec uint64
+	if iface.tsUnit&0x80 != 0 {
+		shift := uint(iface.tsUnit & 0x7F)
+		if shift >= 64 {
+			shift = 63
+		}
+		sec = ts >> shift
+		hi, lo := bits.Mul64(ts&(1<<shift-1), 1e9)
+		nsec, _ = bits.Div64(hi, lo, 1<<shift)
+	} else if iface.tsUnit <= 19 {
+		unit := pow10(iface.tsUnit)
+		sec = ts / unit
+		nsec = ts % unit
+		if iface.tsUnit <= 9 {
+			nsec *= pow10(9 - iface.tsUnit)
+		} else {
+			nsec /= pow10(iface.tsUnit - 9)
+		}
+	} else {
+		return time.Unix(iface.tsOffset, 0).UTC()
+	}
+	return time.Unix(iface.tsOffset+int64(sec), int64(nsec)).UTC()
+}
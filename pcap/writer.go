@@ -0,0 +1,260 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnesting/lhex"
+)
+
+// WriteOptions controls how a capture is written.
+type WriteOptions struct {
+	// LinkType is the link type of every packet.  Unless HasLinkType is set, Encode uses the
+	// link type from an interface comment like those written by Decode, or else
+	// LinkTypeEthernet.  NewWriter always uses LinkType.
+	LinkType LinkType
+
+	// HasLinkType makes Encode use LinkType, even if it's LinkTypeNull.
+	HasLinkType bool
+
+	// SnapLen is the snapshot length recorded in the capture.  If zero, pcapng captures
+	// record no limit and pcap captures record 262144.
+	SnapLen uint32
+
+	// Classic writes the older pcap format instead of pcapng.  A pcap capture can't hold
+	// comments.
+	Classic bool
+
+	// Start is the time of the first packet without a timestamp of its own, and Interval the
+	// time between a packet and the next one if that has no timestamp of its own.  If Start is
+	// zero, the Unix epoch is used.
+	Start    time.Time
+	Interval time.Duration
+}
+
+// Writer writes packets to a pcap or pcapng capture, with timestamps to the nanosecond.
+type Writer struct {
+	w       io.Writer
+	classic bool
+}
+
+// NewWriter returns a Writer writing a capture to w, after writing the capture's header.  The
+// capture has a single interface, and Packet.Interface is ignored.
+func NewWriter(w io.Writer, opts WriteOptions) (*Writer, error) {
+	pw := &Writer{w: w, classic: opts.Classic}
+	le := binary.LittleEndian
+	if opts.Classic {
+		snapLen := opts.SnapLen
+		if snapLen == 0 {
+			snapLen = 262144
+		}
+		hdr := make([]byte, 24)
+		le.PutUint32(hdr, magicNanos)
+		le.PutUint16(hdr[4:], 2)
+		le.PutUint16(hdr[6:], 4)
+		le.PutUint32(hdr[16:], snapLen)
+		le.PutUint32(hdr[20:], uint32(opts.LinkType))
+		_, err := w.Write(hdr)
+		return pw, err
+	}
+
+	shb := make([]byte, 16)
+	le.PutUint32(shb, byteOrderNG)
+	le.PutUint16(shb[4:], 1)
+	le.PutUint64(shb[8:], ^uint64(0)) // section length unknown
+	if err := pw.block(blockSHB, shb); err != nil {
+		return nil, err
+	}
+	idb := make([]byte, 8)
+	le.PutUint16(idb, uint16(opts.LinkType))
+	le.PutUint32(idb[4:], opts.SnapLen)
+	idb = appendOption(idb, optTSResol, []byte{9})
+	idb = appendOption(idb, optEnd, nil)
+	return pw, pw.block(blockIDB, idb)
+}
+
+// appendOption appends a pcapng option to buf.
+func appendOption(buf []byte, code uint16, val []byte) []byte {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint16(hdr[:], code)
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(val)))
+	buf = append(append(buf, hdr[:]...), val...)
+	return append(buf, make([]byte, pad4(len(val))-len(val))...)
+}
+
+// block writes a pcapng block.
+func (w *Writer) block(typ uint32, body []byte) error {
+	buf := make([]byte, 8, len(body)+12)
+	binary.LittleEndian.PutUint32(buf, typ)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(body)+12))
+	buf = append(append(buf, body...), buf[4:8]...)
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// WritePacket writes p to the capture.  If p.Length is less than len(p.Data), the packet's
+// length is taken to be len(p.Data).  Comments are written only to pcapng captures.
+func (w *Writer) WritePacket(p *Packet) error {
+	length := p.Length
+	if length < len(p.Data) {
+		length = len(p.Data)
+	}
+	ts := p.Time.UnixNano()
+	if p.Time.Before(time.Unix(0, 0)) || ts < 0 {
+		return fmt.Errorf("time %v can't be represented", p.Time)
+	}
+	le := binary.LittleEndian
+	if w.classic {
+		sec := uint64(ts) / 1e9
+		if sec > 0xFFFFFFFF {
+			return fmt.Errorf("time %v can't be represented", p.Time)
+		}
+		hdr := make([]byte, 16, 16+len(p.Data))
+		le.PutUint32(hdr, uint32(sec))
+		le.PutUint32(hdr[4:], uint32(uint64(ts)%1e9))
+		le.PutUint32(hdr[8:], uint32(len(p.Data)))
+		le.PutUint32(hdr[12:], uint32(length))
+		_, err := w.w.Write(append(hdr, p.Data...))
+		return err
+	}
+
+	body := make([]byte, 20, 20+pad4(len(p.Data))+16)
+	le.PutUint32(body[4:], uint32(uint64(ts)>>32))
+	le.PutUint32(body[8:], uint32(ts))
+	le.PutUint32(body[12:], uint32(len(p.Data)))
+	le.PutUint32(body[16:], uint32(length))
+	body = append(body, p.Data...)
+	body = append(body, make([]byte, pad4(len(p.Data))-len(p.Data))...)
+	for _, c := range p.Comments {
+		if len(c) > 0xFFFF {
+			c = c[:0xFFFF]
+		}
+		body = appendOption(body, optComment, []byte(c))
+	}
+	if len(p.Comments) > 0 {
+		body = appendOption(body, optEnd, nil)
+	}
+	return w.block(blockEPB, body)
+}
+
+// Patterns matching the comments written by Decode.
+var (
+	packetRE    = regexp.MustCompile(`^packet \d+: `)
+	timeRE      = regexp.MustCompile(`(?:^|: |, )time ([^ ,]+)`)
+	lengthRE    = regexp.MustCompile(`(?:^|: |, )length (\d+)`)
+	interfaceRE = regexp.MustCompile(`^interface \d+: ([A-Z0-9_]+|LinkType\(\d+\))`)
+	packetNRE   = regexp.MustCompile(`^packet\d+$`)
+)
+
+// parseLinkType returns the LinkType named like LinkType.String would name it.
+func parseLinkType(name string) (LinkType, bool) {
+	for t, n := range linkTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	if strings.HasPrefix(name, "LinkType(") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "LinkType("), ")"), 10, 32)
+		return LinkType(n), err == nil
+	}
+	return 0, false
+}
+
+// Encode writes the data in f to w as a capture.  Each segment of f is a packet, and so is each
+// part of a segment starting at a packet comment like "packet 2: ..." as written by Decode.
+// A packet's timestamp and length are taken from a comment at its start that includes
+// "time <RFC 3339 time>" or "length <n>", as in those written by Decode; otherwise packets
+// are timed as described by WriteOptions.
+//
+// In pcapng captures, labels within a packet are written as packet comments, naming the label
+// and its position if it's not the start of the packet, as are comments of the form "comment:
+// <text>".  Labels like "packet1" are omitted.  Wildcard bytes are written as zeros.
+func Encode(w io.Writer, f *lhex.File, opts WriteOptions) error {
+	if !opts.HasLinkType {
+		opts.LinkType = LinkTypeEthernet
+		for it := f.Comments.Iter(0); it.Ofs >= 0; it.Next() {
+			if t, ok := linkTypeComment(it.Comments); ok {
+				opts.LinkType = t
+				break
+			}
+		}
+	}
+	if opts.Start.IsZero() {
+		opts.Start = time.Unix(0, 0)
+	}
+	pw, err := NewWriter(w, opts)
+	if err != nil {
+		return err
+	}
+
+	next := opts.Start
+	for _, s := range f.Segments {
+		for start := s.Offset; start < s.End(); {
+			end := s.End()
+			for it := f.Comments.Iter(start + 1); it.Ofs >= 0 && it.Ofs < end; it.Next() {
+				if hasPacketComment(it.Comments) {
+					end = it.Ofs
+					break
+				}
+			}
+			p := &Packet{Time: next, Data: s.Data[start-s.Offset : end-s.Offset]}
+			for _, c := range f.Comments.Get(start) {
+				if m := timeRE.FindStringSubmatch(c); m != nil {
+					if p.Time, err = time.Parse(time.RFC3339Nano, m[1]); err != nil {
+						return fmt.Errorf("packet at 0x%X: %v", start, err)
+					}
+				}
+				if m := lengthRE.FindStringSubmatch(c); m != nil {
+					p.Length, _ = strconv.Atoi(m[1])
+				}
+			}
+			for it := f.Comments.Iter(start); it.Ofs >= 0 && it.Ofs < end; it.Next() {
+				for _, c := range it.Comments {
+					if strings.HasPrefix(c, "comment: ") {
+						p.Comments = append(p.Comments, strings.TrimPrefix(c, "comment: "))
+					}
+				}
+			}
+			for _, l := range f.Labels.Range(start, end) {
+				switch {
+				case packetNRE.MatchString(l.Name):
+				case l.Offset == start:
+					p.Comments = append(p.Comments, l.Name)
+				default:
+					p.Comments = append(p.Comments, fmt.Sprintf("%s at +0x%X", l.Name, l.Offset-start))
+				}
+			}
+			if err := pw.WritePacket(p); err != nil {
+				return fmt.Errorf("packet at 0x%X: %v", start, err)
+			}
+			next = p.Time.Add(opts.Interval)
+			start = end
+		}
+	}
+	return nil
+}
+
+// hasPacketComment reports whether comments include one like "packet 2: ...".
+func hasPacketComment(comments []string) bool {
+	for _, c := range comments {
+		if packetRE.MatchString(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkTypeComment returns the link type from an interface comment in comments, if any.
+func linkTypeComment(comments []string) (LinkType, bool) {
+	for _, c := range comments {
+		if m := interfaceRE.FindStringSubmatch(c); m != nil {
+			return parseLinkType(m[1])
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package lhex
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Recorder passes reads and writes through to an io.ReadWriter, such as a net.Conn, while
+// writing everything that passes through it to a transcript that Decoder.ReadTranscript can
+// read back.  Data written is recorded as sent and data read as received, each chunk with a
+// comment giving the time it passed through.
+//
+// Recording doesn't interfere with the data passing through.  If writing the transcript fails,
+// recording stops and Close returns the error.
+type Recorder struct {
+	rw  io.ReadWriter
+	w   io.Writer
+	now func() time.Time
+
+	mu  sync.Mutex
+	dir Direction // direction of the block being written, or 0 before the first
+	ofs [2]int64  // offset of the next data in each direction
+	err error
+}
+
+// NewRecorder returns a Recorder passing data through to rw and writing a transcript to w.
+func NewRecorder(rw io.ReadWriter, w io.Writer) *Recorder {
+	return &Recorder{rw: rw, w: w, now: time.Now}
+}
+
+// SetClock sets the function giving the time recorded with each chunk of data, which defaults
+// to time.Now.
+func (r *Recorder) SetClock(now func() time.Time) {
+	r.now = now
+}
+
+// Read reads from the underlying reader, recording the data read as received.
+func (r *Recorder) Read(p []byte) (n int, err error) {
+	n, err = r.rw.Read(p)
+	r.record(Received, p[:n])
+	return n, err
+}
+
+// Write writes to the underlying writer, recording the data written as sent.
+func (r *Recorder) Write(p []byte) (n int, err error) {
+	n, err = r.rw.Write(p)
+	r.record(Sent, p[:n])
+	return n, err
+}
+
+// record adds data sent in direction dir to the transcript.
+func (r *Recorder) record(dir Direction, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	stamp := r.now().UTC().Format(time.RFC3339Nano)
+	if dir != r.dir {
+		if r.dir != 0 {
+			_, r.err = io.WriteString(r.w, "\n")
+		}
+		r.dir = dir
+		r.write("%s # %s\n", dir.marker(), stamp)
+	} else {
+		r.write("# %s\n", stamp)
+	}
+	dmp := NewDumper(r.w, nil)
+	ofs := &r.ofs[dir-1]
+	if r.err == nil {
+		_, r.err = dmp.Seek(*ofs, io.SeekStart)
+	}
+	if r.err == nil {
+		_, r.err = dmp.Write(data)
+	}
+	if r.err == nil {
+		r.err = dmp.Close()
+	}
+	*ofs += int64(len(data))
+}
+
+// write writes a formatted line to the transcript, unless an earlier write failed.
+func (r *Recorder) write(format string, args ...interface{}) {
+	if r.err == nil {
+		_, r.err = fmt.Fprintf(r.w, format, args...)
+	}
+}
+
+// Close closes the underlying io.ReadWriter, if it has a Close method, and returns the first
+// error encountered writing the transcript, if any.  It does not close the transcript's
+// writer.
+func (r *Recorder) Close() error {
+	var err error
+	if c, ok := r.rw.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// recordingConn is a net.Conn recording its traffic through a Recorder.
+type recordingConn struct {
+	net.Conn
+	rec *Recorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error)  { return c.rec.Read(p) }
+func (c *recordingConn) Write(p []byte) (int, error) { return c.rec.Write(p) }
+func (c *recordingConn) Close() error                { return c.rec.Close() }
+
+// RecordConn returns a net.Conn that behaves like conn, while writing a transcript of its
+// traffic to w as a Recorder does.  Data written to the connection is recorded as sent and
+// data read from it as received, so recording a client's connection to a server yields a
+// transcript that lhextest.Replay can play back.
+func RecordConn(conn net.Conn, w io.Writer) net.Conn {
+	return &recordingConn{Conn: conn, rec: NewRecorder(conn, w)}
+}
@@ -0,0 +1,111 @@
+package lhex_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestRecorder(t *testing.T) {
+	var out bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n"), ioutil.Discard}
+	rec := lhex.NewRecorder(rw, &out)
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rec.SetClock(func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	})
+
+	io.WriteString(rec, "GET / HTTP/1.1\r\n")
+	io.WriteString(rec, "\r\n")
+	io.CopyN(ioutil.Discard, rec, 20)
+	io.Copy(ioutil.Discard, rec)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `> # 2024-05-01T12:00:00.001Z
+00000000  47 45 54 20 2F 20 48 54  54 50 2F 31 2E 31 0D 0A  |GET / HTTP/1.1..|
+# 2024-05-01T12:00:00.002Z
+00000010  0D 0A                                             |..|
+
+< # 2024-05-01T12:00:00.003Z
+00000000  48 54 54 50 2F 31 2E 31  20 32 30 34 20 4E 6F 20  |HTTP/1.1 204 No |
+00000010  43 6F 6E 74                                       |Cont|
+# 2024-05-01T12:00:00.004Z
+00000014  65 6E 74 0D 0A 0D 0A                              |ent....|
+`
+	if out.String() != expected {
+		t.Errorf("Recorder wrote:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	tr, err := lhex.DecodeTranscript(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Messages) != 2 || string(tr.Messages[0].Data) != "GET / HTTP/1.1\r\n\r\n" ||
+		string(tr.Messages[1].Data) != "HTTP/1.1 204 No Content\r\n\r\n" {
+		t.Errorf("recorded transcript decoded as %q", tr.Messages)
+	}
+	if got := tr.Received.Comments.Get(0x14); len(got) != 1 || got[0] != "2024-05-01T12:00:00.004Z" {
+		t.Errorf("received comments at 0x14 = %q", got)
+	}
+}
+
+func TestRecordConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 4)
+		io.ReadFull(server, buf)
+		server.Write([]byte("pong"))
+	}()
+
+	var out bytes.Buffer
+	conn := lhex.RecordConn(client, &out)
+	io.WriteString(conn, "ping")
+	io.ReadFull(conn, make([]byte, 4))
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := lhex.DecodeTranscript(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Messages) != 2 || tr.Messages[0].Dir != lhex.Sent || string(tr.Messages[0].Data) != "ping" ||
+		tr.Messages[1].Dir != lhex.Received || string(tr.Messages[1].Data) != "pong" {
+		t.Errorf("recorded transcript decoded as %q", tr.Messages)
+	}
+}
+
+func TestRecorderWriteError(t *testing.T) {
+	var sent bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), &sent}
+
+	// Let the direction marker through, so that it's writing the data line that fails.
+	rec := lhex.NewRecorder(rw, &failWriter{n: len("> # 2024-05-01T12:00:00Z\n")})
+	rec.SetClock(func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) })
+	if n, err := io.WriteString(rec, "GET / HTTP/1.1\r\n"); n != 16 || err != nil {
+		t.Errorf("Write returned %d, %v; the failing transcript shouldn't affect it", n, err)
+	}
+	io.WriteString(rec, "\r\n")
+	if sent.String() != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("data passed through as %q", sent.String())
+	}
+	if err := rec.Close(); err != errFail {
+		t.Errorf("Close should return %v, got %v", errFail, err)
+	}
+}
@@ -0,0 +1,132 @@
+package lhex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// redaction is a region whose bytes a Dumper writes as wildcards.
+type redaction struct {
+	start, end int64
+	first      int64     // offset of the first byte written since the last note
+	h          hash.Hash // HMAC of the bytes written since the last note, if there is a key
+	n          int64     // number of bytes written since the last note
+	noted      bool      // whether the note describing the bytes written has been written
+}
+
+// Redact arranges for the bytes written to the Dumper in the region [start, end) to be replaced
+// by wildcards, like "??", for instance to remove secrets from a dump before sharing it.
+// Labels and comments in the region are kept.  Once the region has been written, a comment
+// gives the number of bytes redacted, along with their HMAC-SHA-256 if SetRedactionKey was
+// called.  Since the comment isn't complete until the region's last byte is written, it appears
+// at the end of the region, ahead of any following data.  If the region is written in parts
+// separated by a Seek, each part gets its own comment.  Overlapping regions are combined.  This
+// should be called before the first Write.
+func (d *Dumper) Redact(start, end int64) {
+	if end <= start {
+		return
+	}
+	for i := 0; i < len(d.redactions); {
+		if r := d.redactions[i]; r.start <= end && r.end >= start {
+			if r.start < start {
+				start = r.start
+			}
+			if r.end > end {
+				end = r.end
+			}
+			d.redactions = append(d.redactions[:i], d.redactions[i+1:]...)
+			continue
+		}
+		i++
+	}
+	i := sort.Search(len(d.redactions), func(i int) bool { return d.redactions[i].start > start })
+	d.redactions = append(d.redactions, nil)
+	copy(d.redactions[i+1:], d.redactions[i:])
+	d.redactions[i] = &redaction{start: start, end: end}
+}
+
+// SetRedactionKey arranges for the comment following each redacted region to include the
+// HMAC-SHA-256 of the redacted bytes using key, so that dumps redacted with the same key can
+// still be compared with each other.  A plain hash isn't offered, because short secrets like
+// passwords could be recovered from it by trying every possibility.  Anyone holding the key
+// can do the same, so it should be kept as secret as the data.  This should be called before
+// the first Write.
+func (d *Dumper) SetRedactionKey(key []byte) {
+	d.redactKey = key
+}
+
+// redacted looks at the next max bytes to be written, and returns how many of them are either
+// all redacted or all not, along with the redaction they belong to, if any.
+func (d *Dumper) redacted(max int) (int, *redaction) {
+	ofs := d.end()
+	for _, r := range d.redactions {
+		switch {
+		case r.end <= ofs:
+			continue
+		case r.start >= ofs+int64(max):
+			return max, nil
+		case r.start > ofs:
+			return int(r.start - ofs), nil
+		case r.end < ofs+int64(max):
+			return int(r.end - ofs), r
+		default:
+			return max, r
+		}
+	}
+	return max, nil
+}
+
+// writeRedacted hashes p, which belongs to r, and writes it as wildcards.
+func (d *Dumper) writeRedacted(p []byte, r *redaction) {
+	if r.noted {
+		r.n, r.noted = 0, false
+	}
+	if r.n == 0 {
+		r.first = d.end()
+		if d.redactKey != nil {
+			r.h = hmac.New(sha256.New, d.redactKey)
+		}
+	}
+	if r.h != nil {
+		r.h.Write(p)
+	}
+	r.n += int64(len(p))
+	var zeros [0x10]byte
+	for n := 0; n < len(p); n += len(zeros) {
+		k := len(p) - n
+		if k > len(zeros) {
+			k = len(zeros)
+		}
+		d.write(zeros[:k], zeros[:k])
+	}
+}
+
+// nextRedactionEnd returns the end of the next redaction at or after the current offset whose
+// note hasn't been written, or <0 if there is none.
+func (d *Dumper) nextRedactionEnd() int64 {
+	for _, r := range d.redactions {
+		if !r.noted && r.end >= d.data.ofs {
+			return r.end
+		}
+	}
+	return -1
+}
+
+// writeRedactionNotes writes the note for each redaction ending at ofs, or for every redaction
+// written so far if ofs < 0.
+func (d *Dumper) writeRedactionNotes(ofs int64) {
+	for _, r := range d.redactions {
+		if r.noted || r.n == 0 || ofs >= 0 && r.end != ofs {
+			continue
+		}
+		if r.h != nil {
+			fmt.Fprintf(d.w, "# redacted 0x%X bytes at %08X, hmac-sha256 %x\n", r.n, r.first, r.h.Sum(nil))
+		} else {
+			fmt.Fprintf(d.w, "# redacted 0x%X bytes at %08X\n", r.n, r.first)
+		}
+		r.noted = true
+	}
+}
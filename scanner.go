@@ -2,10 +2,11 @@ package lhex
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
 )
 
 type scanner struct {
@@ -14,6 +15,19 @@ type scanner struct {
 	ch   byte
 	off  int
 	eol  bool
+
+	// Buffers reused from line to line.  Callers must copy anything they want to keep from a
+	// decoded line before decoding the next one.
+	long []byte // holds lines too long to fit in rd's buffer
+	data []byte // holds the decoded data bytes
+	mask []byte // holds the mask for data, if it has wildcards
+	refs []ref  // holds the references found in the data
+
+	dialect   *Dialect // if not nil, describes dumps embedded in other output
+	rewritten []byte   // holds lines rewritten by dialect
+	inDump    bool     // with a dialect, whether the last line read was part of a dump
+	base      uint64   // with dialect.Rebase, the first offset of the current dump
+	based     bool     // whether base is set
 }
 
 func newScanner(r io.Reader) *scanner {
@@ -53,59 +67,209 @@ func (d *scanner) rewind(i int) {
 	d.next()
 }
 
-// decodeLine reads and decodes a single line.  Returns io.EOF if no data was read.
-func (d *scanner) decodeLine() (offset int64, hasOffset bool, data []byte, label string, err error) {
+// line holds the decoded contents of a single line of input.
+type line struct {
+	offset    int64
+	hasOffset bool
+	data      []byte
+	refs      []ref // typed integer references, whose bytes in data are placeholders
+	fill      int64 // if nonzero, data is a pattern repeated to fill this many bytes
+	mask      []byte
+	label     string
+	dir       Direction // if nonzero, the line is a direction marker
+	dumpStart bool      // with a dialect, the line starts a dump embedded in other output
+
+	// source holds the text of a data line containing literals or references, which
+	// KeepLiterals preserves as a comment.
+	source string
+
+	// comment holds the text of a line consisting only of a comment, without
+	// the leading '#' and the single space following it, if any.
+	comment    string
+	hasComment bool
+}
+
+// readLine reads the next line of input, without allocating in the common case.  The line is
+// only valid until the next call.
+func (d *scanner) readLine() ([]byte, error) {
+	line, err := d.rd.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	d.long = append(d.long[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = d.rd.ReadSlice('\n')
+		d.long = append(d.long, line...)
+	}
+	return d.long, err
+}
+
+// decodeLine reads and decodes a single line.  Returns io.EOF if no data was read.  The
+// returned line's data is only valid until the next call.
+func (d *scanner) decodeLine() (ln line, err error) {
 	//defer gotrace.In("decodeLine")()
-	d.line, err = d.rd.ReadBytes('\n')
-	if err != nil {
-		if err != io.EOF || len(d.line) == 0 {
-			//gotrace.Log(err.Error())
-			return 0, false, nil, "", err
+	for {
+		d.line, err = d.readLine()
+		if err != nil {
+			if err != io.EOF || len(d.line) == 0 {
+				//gotrace.Log(err.Error())
+				return line{}, err
+			}
+		}
+		if d.dialect == nil {
+			d.rewind(0)
+			return d.scanLine()
+		}
+		if ok, err := d.applyDialect(); err != nil {
+			return line{}, err
+		} else if !ok || len(d.line) == 0 {
+			d.endDump()
+			continue
 		}
+		d.rewind(0)
+		if ln, err = d.scanLine(); err == nil || d.dialect.Line != nil {
+			ln.dumpStart = !d.inDump
+			d.inDump = true
+			return ln, err
+		}
+		// not part of a dump
+		d.endDump()
 	}
-	d.rewind(0)
-	return d.scanLine()
 }
 
-func (d *scanner) scanLine() (offset int64, hasOffset bool, data []byte, label string, err error) {
+// endDump notes that the line just read isn't part of a dump, ending any dump before it.
+func (d *scanner) endDump() {
+	d.inDump, d.based = false, false
+}
+
+func (d *scanner) scanLine() (ln line, err error) {
 	//defer gotrace.In("scanLine")()
 	if isHex(d.ch) {
-		if offset, hasOffset, err = d.decodeOffset(); err != nil {
+		if ln.offset, ln.hasOffset, err = d.decodeOffset(); err != nil {
 			return
 		}
 		//gotrace.Log("= offset %v %X", hasOffset, offset)
 	} else if d.ch == ':' {
 		d.next()
-		label, err = d.decodeLabel()
+		ln.label, err = d.decodeLabel()
+		return
+	} else if (d.ch == '>' || d.ch == '<') && d.decodeDirection(&ln) {
 		return
 	}
 
 	d.skipSpacesOrHyphen()
-	if isHex(d.ch) {
-		data = make([]byte, 16)
-		var i int
-		for i = 0; i < len(data); i++ {
-			if _, err = d.decodeHexBytes(data[i : i+1]); err != nil {
+	d.skipSymbol()
+	if d.ch == '.' {
+		err = d.decodeDirective(&ln)
+		return
+	}
+	data, refs, mask := d.data[:0], d.refs[:0], d.mask[:0]
+	var authored bool
+	for {
+		if d.ch == '?' || isHex(d.ch) && d.off+1 < len(d.line) && d.line[d.off+1] == '?' {
+			var b, m byte
+			if b, m, err = d.decodeWildcard(); err != nil {
 				return
 			}
-			//gotrace.Log("= char %s", hex.EncodeToString(data[i:i+1]))
-			d.skipSpacesOrHyphen()
-			if !isHex(d.ch) {
-				//gotrace.Log("done looking for hex chars, !hex(%c)", d.ch)
-				break
+			for len(mask) < len(data) {
+				mask = append(mask, 0xFF)
+			}
+			data, mask = append(data, b), append(mask, m)
+		} else if isHex(d.ch) {
+			var b [1]byte
+			if _, err = d.decodeHexBytes(b[:]); err != nil {
+				return
+			}
+			data = append(data, b[0])
+		} else if d.ch == '"' {
+			var str string
+			if str, err = d.decodeString(); err != nil {
+				return
+			}
+			data = append(data, str...)
+			authored = true
+		} else if r, ok, rerr := d.decodeRef(); rerr != nil {
+			return ln, rerr
+		} else if ok {
+			r.pos = len(data)
+			refs = append(refs, r)
+			for i := 0; i < r.typ.size; i++ {
+				data = append(data, 0)
+			}
+		} else {
+			break
+		}
+		d.skipSpacesOrHyphen()
+	}
+	d.data, d.refs, d.mask = data, refs, mask
+	if len(data) > 0 {
+		ln.data = data
+		if len(mask) > 0 {
+			for len(mask) < len(data) {
+				mask = append(mask, 0xFF)
 			}
+			ln.mask, d.mask = mask, mask
+		}
+		if len(refs) > 0 {
+			ln.refs = refs
+			authored = true
+		}
+		if authored {
+			ln.source = string(bytes.TrimRight(d.line, "\r\n"))
 		}
-		data = data[:i+1]
+	} else if d.ch == '#' && !ln.hasOffset {
+		ln.comment, ln.hasComment = d.decodeComment(), true
 	}
 
 	return
 }
 
+// decodeComment returns the text of the comment at the current position, which
+// must be a '#'.
+func (d *scanner) decodeComment() string {
+	d.next()
+	if d.ch == ' ' {
+		d.next()
+	}
+	start := d.off
+	if d.eol {
+		return ""
+	}
+	text := d.line[start:]
+	for len(text) > 0 && (text[len(text)-1] == '\n' || text[len(text)-1] == '\r') {
+		text = text[:len(text)-1]
+	}
+	d.skipComment()
+	return string(text)
+}
+
+// decodeDirection decodes a direction marker line, made up of a '>' or '<' optionally followed
+// by a comment, at the current position.  If the line isn't one, it returns false and the
+// position is unchanged.
+func (d *scanner) decodeDirection(ln *line) bool {
+	start, ch := d.off, d.ch
+	d.next()
+	d.skipSpaces()
+	if !d.eol && d.ch != '#' && d.ch != '\r' {
+		d.rewind(start)
+		return false
+	}
+	ln.dir = Received
+	if ch == '>' {
+		ln.dir = Sent
+	}
+	if d.ch == '#' {
+		ln.comment, ln.hasComment = d.decodeComment(), true
+	}
+	return true
+}
+
 func (d *scanner) decodeLabel() (label string, err error) {
 	var notFirst bool
 	start := d.off
 	for isLabel(d.ch, notFirst) {
 		d.next()
+		notFirst = true
 	}
 	label = string(d.line[start:d.off])
 	d.skipSpaces()
@@ -128,6 +292,19 @@ func (d *scanner) skipSpacesOrHyphen() {
 	}
 }
 
+// skipSymbol skips over a label-relative offset column like "<foo+0x12>", as written by a
+// Dumper with SetRelative, along with any spaces following it.
+func (d *scanner) skipSymbol() {
+	if d.ch != '<' {
+		return
+	}
+	for !d.eol && d.ch != '>' {
+		d.next()
+	}
+	d.next()
+	d.skipSpacesOrHyphen()
+}
+
 func (d *scanner) skipComment() {
 	if d.ch == '#' {
 		d.eol = true // just pretend we're at the end of the line
@@ -149,29 +326,177 @@ func (d *scanner) decodeHexBytes(buf []byte) (n int, err error) {
 	return
 }
 
-func rightAlign(data []byte) []byte {
-	i := cap(data) - len(data)
-	data = data[:cap(data)]
-	if i > 0 {
-		copy(data[i:], data)
-		for i--; i >= 0; i-- {
-			data[i] = 0
+// decodeDirective decodes a directive at the current position, which must be a '.'.  The
+// only directive is ".fill <length> [pattern]", describing a region of length bytes made up
+// of the hex bytes in pattern repeated, or zeros if there is no pattern.
+func (d *scanner) decodeDirective(ln *line) error {
+	start := d.off
+	for !d.eol && d.ch != ' ' {
+		d.next()
+	}
+	if name := string(d.line[start:d.off]); name != ".fill" {
+		return fmt.Errorf("unknown directive %q", name)
+	}
+	d.skipSpaces()
+	start = d.off
+	for !d.eol && d.ch != ' ' && d.ch != '#' {
+		d.next()
+	}
+	n, err := parseNumber(string(d.line[start:d.off]))
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid .fill length %q", d.line[start:d.off])
+	}
+	d.skipSpacesOrHyphen()
+	data := d.data[:0]
+	for isHex(d.ch) {
+		var b [1]byte
+		if _, err = d.decodeHexBytes(b[:]); err != nil {
+			return err
 		}
+		data = append(data, b[0])
+		d.skipSpacesOrHyphen()
+	}
+	if len(data) == 0 {
+		data = append(data, 0)
+	}
+	d.skipComment()
+	if !d.eol {
+		return fmt.Errorf("illegal text after .fill: %q", d.line[d.off:])
 	}
-	return data
+	d.data = data
+	ln.data, ln.fill = data, n
+	return nil
+}
+
+// decodeString decodes a double-quoted string literal at the current position, which may
+// contain the same escapes as a Go string literal.
+func (d *scanner) decodeString() (string, error) {
+	start := d.off
+	for d.next(); !d.eol && d.ch != '"'; d.next() {
+		if d.ch == '\\' {
+			d.next()
+		}
+	}
+	if d.eol {
+		return "", fmt.Errorf("unterminated string literal: %q", d.line[start:])
+	}
+	d.next()
+	str, err := strconv.Unquote(string(d.line[start:d.off]))
+	if err != nil {
+		return "", fmt.Errorf("invalid string literal %s", d.line[start:d.off])
+	}
+	return str, nil
+}
+
+// decodeRef decodes a typed integer like "u32le(end - start)" at the current position.  If
+// there isn't one, ok is false and the position is unchanged.
+func (d *scanner) decodeRef() (r ref, ok bool, err error) {
+	if d.ch != 'u' && d.ch != 'i' {
+		return r, false, nil
+	}
+	start := d.off
+	for d.ch >= 'a' && d.ch <= 'z' || d.ch >= '0' && d.ch <= '9' {
+		d.next()
+	}
+	typ, found := lookupIntType(d.line[start:d.off])
+	if !found || d.ch != '(' {
+		d.rewind(start)
+		return r, false, nil
+	}
+	d.next()
+	if r.x, err = d.decodeExpr(); err != nil {
+		return r, false, err
+	}
+	if d.ch != ')' {
+		return r, false, fmt.Errorf("expected ')' in %q", d.line[start:d.off])
+	}
+	d.next()
+	r.typ = typ
+	return r, true, nil
+}
+
+// decodeExpr decodes a sum of labels and numbers, like "end - start" or "foo+0x10".  Since
+// label names may contain '-', subtraction must be preceded by a space.
+func (d *scanner) decodeExpr() (x expr, err error) {
+	var neg bool
+	for {
+		d.skipSpaces()
+		t := term{neg: neg}
+		if d.ch == '-' && d.off+1 < len(d.line) && d.line[d.off+1] >= '0' && d.line[d.off+1] <= '9' {
+			t.neg = !t.neg
+			d.next()
+		}
+		start := d.off
+		switch {
+		case d.ch >= '0' && d.ch <= '9':
+			for d.ch >= '0' && d.ch <= '9' || d.ch >= 'a' && d.ch <= 'z' || d.ch >= 'A' && d.ch <= 'Z' || d.ch == '_' {
+				d.next()
+			}
+			if t.num, err = parseNumber(string(d.line[start:d.off])); err != nil {
+				return nil, fmt.Errorf("invalid number %q", d.line[start:d.off])
+			}
+		case isLabel(d.ch, false):
+			for isLabel(d.ch, true) {
+				d.next()
+			}
+			t.label = string(d.line[start:d.off])
+		default:
+			return nil, fmt.Errorf("expected label or number at %q", d.line[start:])
+		}
+		x = append(x, t)
+		d.skipSpaces()
+		switch d.ch {
+		case '+':
+			neg = false
+		case '-':
+			neg = true
+		default:
+			return x, nil
+		}
+		d.next()
+	}
+}
+
+// decodeWildcard decodes a byte written with '?' in place of either or both of its hex
+// digits, returning the byte with zeros in place of the unknown bits, and a mask with the
+// known bits set.
+func (d *scanner) decodeWildcard() (b, m byte, err error) {
+	start := d.off
+	for i := 0; i < 2; i++ {
+		b, m = b<<4, m<<4
+		switch {
+		case d.ch == '?':
+		case isHex(d.ch):
+			h := d.ch - '0'
+			if d.ch >= 'A' {
+				h = d.ch - 'A' + 10
+			}
+			b, m = b|h, m|0xF
+		default:
+			return 0, 0, fmt.Errorf("illegal character %q reading wildcard: %q", d.ch, d.line[start:d.off+1])
+		}
+		d.next()
+	}
+	if isHex(d.ch) || d.ch == '?' {
+		err = fmt.Errorf("too many characters reading wildcard: %q", d.line[start:d.off+1])
+	} else if !d.eol && d.ch != ' ' && d.ch != '-' {
+		err = fmt.Errorf("illegal character %q reading wildcard: %q", d.ch, d.line[start:d.off+1])
+	}
+	return
 }
 
 func (d *scanner) decodeOffset() (offset int64, hasOffset bool, err error) {
-	data := make([]byte, 8)
-	n, err := d.decodeHexBytes(data)
+	var data [8]byte
+	n, err := d.decodeHexBytes(data[:])
 	if err != nil {
 		return 0, false, err
 	}
-	data = rightAlign(data[:n])
-	offset = int64(binary.BigEndian.Uint64(data))
+	for _, b := range data[:n] {
+		offset = offset<<8 | int64(b)
+	}
 	hasOffset = true
 	if offset < 0 {
-		return 0, false, fmt.Errorf("offset too large: %X", hex.EncodeToString(data))
+		return 0, false, fmt.Errorf("offset too large: %X", uint64(offset))
 	}
 	return
 }
@@ -242,6 +567,9 @@ func isHex(b byte) bool {
 	return b >= '0' && b <= '9' || b >= 'A' && b <= 'F'
 }
 
+// isLabel reports whether b may appear in a label name.  Besides letters, digits and '_', names
+// may contain the '-', '.', '$' and '@' characters that commonly appear in symbol names.
 func isLabel(b byte, notFirst bool) bool {
-	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b == '_' || b == '-' || notFirst && b >= '0' && b <= '9'
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b == '_' || b == '-' || b == '.' || b == '$' || b == '@' ||
+		notFirst && b >= '0' && b <= '9'
 }
@@ -0,0 +1,14 @@
+//go:build go1.21
+// +build go1.21
+
+package lhex
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// LogValue implements slog.LogValuer, logging b as a complete dump, as printed by %+v.
+func (b Bytes) LogValue() slog.Value {
+	return slog.StringValue(strings.TrimSuffix(Dump(b.Data, b.Offset, b.Labels), "\n"))
+}
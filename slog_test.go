@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package lhex_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestBytesLogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("received", "payload", lhex.Bytes{Data: []byte("hi\n"), Offset: 0x10})
+	expected := `{"level":"INFO","msg":"received","payload":"00000010  68 69 0A                                          |hi.|"}`
+	if got := strings.TrimSpace(buf.String()); got != expected {
+		t.Errorf("logged %s, want %s", got, expected)
+	}
+}
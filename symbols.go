@@ -0,0 +1,69 @@
+package lhex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Nearest returns the label at or most closely preceding ofs, and the displacement of ofs from
+// it.  If several labels share that offset, the first by name is returned.  If no label
+// precedes ofs, ok will be false.
+func (l *Labels) Nearest(ofs int64) (name string, disp int64, ok bool) {
+	if l == nil {
+		return "", 0, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] > ofs })
+	if i == 0 {
+		return "", 0, false
+	}
+	at := l.offsets[i-1]
+	return l.offLabels[at][0], ofs - at, true
+}
+
+// Symbolize describes ofs relative to the nearest preceding label, in the form "foo+0x12", or
+// just "foo" if ofs is the label's offset.  If no label precedes ofs, ofs is given in hex,
+// like "0x1234".  The result can be turned back into an offset using Resolve.
+func (l *Labels) Symbolize(ofs int64) string {
+	name, disp, ok := l.Nearest(ofs)
+	switch {
+	case !ok:
+		return fmt.Sprintf("0x%X", ofs)
+	case disp == 0:
+		return name
+	default:
+		return fmt.Sprintf("%s+0x%X", name, disp)
+	}
+}
+
+// Resolve parses an expression of the form "foo", "foo+0x12", "foo-4" or "0x1234" and returns
+// the offset it refers to.  Displacements and plain numbers are decimal unless prefixed with
+// "0x", as in data lines.  Since label names may themselves contain '-', an expression is first
+// looked up as a label in its entirety.
+func (l *Labels) Resolve(expr string) (int64, error) {
+	expr = strings.TrimSpace(expr)
+	if ofs, ok := l.Get(expr); ok {
+		return ofs, nil
+	}
+	if n, err := parseNumber(expr); err == nil {
+		return n, nil
+	}
+	if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		name := strings.TrimSpace(expr[:i])
+		disp, err := parseNumber(strings.TrimSpace(expr[i+1:]))
+		if err != nil {
+			return 0, fmt.Errorf("invalid displacement in %q: %v", expr, err)
+		}
+		ofs, ok := l.Get(name)
+		if !ok {
+			return 0, fmt.Errorf("undefined label %q", name)
+		}
+		if expr[i] == '-' {
+			disp = -disp
+		}
+		return ofs + disp, nil
+	}
+	return 0, fmt.Errorf("undefined label %q", expr)
+}
@@ -0,0 +1,116 @@
+package lhex
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Logger is implemented by loggers like *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// TeeOptions controls what TeeReader and TeeWriter dump.
+type TeeOptions struct {
+	// Logger, if not nil, is given the dump of each read or write as a single message, instead
+	// of writing it to the tee's writer.
+	Logger Logger
+
+	// Labels, if not nil, labels the dump, with offsets giving the position in the stream.
+	Labels *Labels
+
+	// Limit is the most bytes that will be dumped in all, or 0 for no limit.  Data past the
+	// limit still passes through.
+	Limit int64
+
+	// Sample, if greater than 1, dumps only one in every Sample reads or writes, starting with
+	// the first.
+	Sample int
+}
+
+// tee dumps the data passing through a TeeReader or TeeWriter.
+type tee struct {
+	w      io.Writer
+	opts   TeeOptions
+	ofs    int64 // position in the stream
+	dumped int64 // bytes dumped so far
+	calls  int
+}
+
+// dump dumps p, the next data passing through, if the options call for it.
+func (t *tee) dump(p []byte) {
+	ofs := t.ofs
+	t.ofs += int64(len(p))
+	if len(p) == 0 {
+		return
+	}
+	t.calls++
+	if t.opts.Sample > 1 && (t.calls-1)%t.opts.Sample != 0 {
+		return
+	}
+	var note string
+	if t.opts.Limit > 0 {
+		if t.dumped >= t.opts.Limit {
+			return
+		}
+		if left := t.opts.Limit - t.dumped; int64(len(p)) > left {
+			p = p[:left]
+			note = fmt.Sprintf("# dump limit of %d bytes reached\n", t.opts.Limit)
+		}
+	}
+	t.dumped += int64(len(p))
+
+	// Only include labels within p, so that a label between two reads isn't written twice.
+	var labels *Labels
+	if found := t.opts.Labels.Range(ofs, ofs+int64(len(p))); len(found) > 0 {
+		labels = &Labels{}
+		for _, l := range found {
+			labels.Set(l.Name, l.Offset)
+		}
+	}
+	s := Dump(p, ofs, labels) + note
+	if t.opts.Logger != nil {
+		t.opts.Logger.Printf("%s", strings.TrimSuffix(s, "\n"))
+	} else {
+		io.WriteString(t.w, s)
+	}
+}
+
+// teeReader is the io.Reader returned by TeeReader.
+type teeReader struct {
+	r io.Reader
+	t tee
+}
+
+// TeeReader returns an io.Reader that reads from r, writing a hex dump of the data it reads to
+// w, or logging it if opts.Logger is set.  Each read is dumped separately, with offsets giving
+// its position in the stream.  Errors writing the dump are ignored.
+func TeeReader(r io.Reader, w io.Writer, opts TeeOptions) io.Reader {
+	return &teeReader{r: r, t: tee{w: w, opts: opts}}
+}
+
+func (tr *teeReader) Read(p []byte) (n int, err error) {
+	n, err = tr.r.Read(p)
+	tr.t.dump(p[:n])
+	return n, err
+}
+
+// teeWriter is the io.Writer returned by TeeWriter.
+type teeWriter struct {
+	w io.Writer
+	t tee
+}
+
+// TeeWriter returns an io.Writer that writes to w, writing a hex dump of the data written to
+// dump, or logging it if opts.Logger is set.  Each write is dumped separately, with offsets
+// giving its position in the stream.  Errors writing the dump are ignored.
+func TeeWriter(w, dump io.Writer, opts TeeOptions) io.Writer {
+	return &teeWriter{w: w, t: tee{w: dump, opts: opts}}
+}
+
+func (tw *teeWriter) Write(p []byte) (n int, err error) {
+	n, err = tw.w.Write(p)
+	tw.t.dump(p[:n])
+	return n, err
+}
@@ -0,0 +1,65 @@
+package lhex_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestTeeReader(t *testing.T) {
+	var dump bytes.Buffer
+	labels := lhex.NewLabels(map[string]int64{"body": 4})
+	r := lhex.TeeReader(strings.NewReader("HEADbody data"), &dump, lhex.TeeOptions{Labels: labels, Limit: 10})
+	buf := make([]byte, 4)
+	var got []byte
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+	}
+	if string(got) != "HEADbody data" {
+		t.Errorf("TeeReader passed through %q", got)
+	}
+	expected := `00000000  48 45 41 44                                       |HEAD|
+:body
+00000004  62 6F 64 79                                       |body|
+00000008  20 64                                             | d|
+# dump limit of 10 bytes reached
+`
+	if dump.String() != expected {
+		t.Errorf("TeeReader dumped:\n%s\nexpected:\n%s", dump.String(), expected)
+	}
+}
+
+// logger collects logged messages.
+type logger []string
+
+func (l *logger) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestTeeWriter(t *testing.T) {
+	var out bytes.Buffer
+	var log logger
+	w := lhex.TeeWriter(&out, ioutil.Discard, lhex.TeeOptions{Logger: &log, Sample: 2})
+	for _, s := range []string{"one", "two", "three"} {
+		io.WriteString(w, s)
+	}
+	if out.String() != "onetwothree" {
+		t.Errorf("TeeWriter passed through %q", out.String())
+	}
+	expected := logger{
+		"00000000  6F 6E 65                                          |one|",
+		"00000006  74 68 72 65 65                                    |three|",
+	}
+	if fmt.Sprint(log) != fmt.Sprint(expected) {
+		t.Errorf("TeeWriter logged:\n%q\nexpected:\n%q", log, expected)
+	}
+}
@@ -0,0 +1,173 @@
+package lhex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Direction identifies which side of a conversation sent some data.
+type Direction int
+
+const (
+	// Sent is data sent by the client, or by whoever made the recording, marked by a line
+	// holding a '>'.
+	Sent Direction = iota + 1
+
+	// Received is data sent by the server, or the other side, marked by a line holding a '<'.
+	Received
+)
+
+func (dir Direction) String() string {
+	switch dir {
+	case Sent:
+		return "sent"
+	case Received:
+		return "received"
+	}
+	return "unknown"
+}
+
+// marker returns the character marking the start of a block of data sent in direction dir.
+func (dir Direction) marker() string {
+	if dir == Sent {
+		return ">"
+	}
+	return "<"
+}
+
+// Message is a contiguous run of data sent in one direction, at Offset within that
+// direction's stream.
+type Message struct {
+	Dir    Direction
+	Offset int64
+	Data   []byte
+
+	// Mask, if not nil, holds the known bits of each byte in Data, as in Segment.
+	Mask []byte
+}
+
+// End returns the offset immediately following the message's data.
+func (m Message) End() int64 { return m.Offset + int64(len(m.Data)) }
+
+// Transcript holds a conversation decoded from a hex dump with direction markers.  Messages
+// lists what each side sent in the order it appears, and Sent and Received hold everything
+// sent in each direction, with offsets, labels and comments of their own.
+type Transcript struct {
+	Messages []Message
+	Sent     *File
+	Received *File
+}
+
+// Stream returns the data sent in direction dir.
+func (t *Transcript) Stream(dir Direction) *File {
+	if dir == Sent {
+		return t.Sent
+	}
+	return t.Received
+}
+
+// DecodeTranscript reads the transcript in r in its entirety and returns its contents.
+func DecodeTranscript(r io.Reader) (*Transcript, error) {
+	return NewDecoder(r).ReadTranscript()
+}
+
+// block is the range of a direction's stream covered by one block of a transcript.
+type block struct {
+	dir        Direction
+	start, end int64
+}
+
+// ReadTranscript reads the rest of the input as a transcript of a conversation and returns its
+// contents.  A line holding just a '>' or '<', optionally followed by a comment, starts a block
+// of data sent in that direction, which lasts until the next such line.  Each direction has
+// offsets of its own, and data without an offset follows the data previously sent in the same
+// direction.  Labels and references are likewise kept separately for each direction.
+//
+// Each block yields a Message for each contiguous run of data within it.  Comments and labels
+// ahead of the first block belong to it, but data there is an error.  With a dialect set,
+// each direction's dumps are placed as they would be by Read, so a dump going back over
+// earlier data in the same direction is moved past it rather than being an error.
+func (d *Decoder) ReadTranscript() (*Transcript, error) {
+	var streams [2]*Decoder
+	for i := range streams {
+		streams[i] = NewDecoder(bytes.NewReader(nil))
+		streams[i].keepLiterals = d.keepLiterals
+		streams[i].scan.dialect = d.scan.dialect
+	}
+	var blocks []block
+	var early []line
+	var cur *Decoder
+	for {
+		ln, err := d.scan.decodeLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case ln.dir != 0:
+			if cur != nil {
+				cur.settle()
+				blocks[len(blocks)-1].end = cur.end()
+			}
+			cur = streams[ln.dir-1]
+			cur.settle()
+			blocks = append(blocks, block{dir: ln.dir, start: cur.end()})
+			for i := range early {
+				if err := cur.add(&early[i]); err != nil {
+					return nil, err
+				}
+			}
+			early = nil
+			if ln.hasComment {
+				ln.dir = 0
+				err = cur.add(&ln)
+			}
+		case cur != nil:
+			err = cur.add(&ln)
+		case ln.label != "" || ln.hasComment:
+			early = append(early, line{label: ln.label, comment: ln.comment, hasComment: ln.hasComment})
+		case ln.hasOffset || len(ln.data) > 0:
+			err = errors.New("data ahead of the first '>' or '<'")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cur != nil {
+		cur.settle()
+		blocks[len(blocks)-1].end = cur.end()
+	}
+
+	var files [2]*File
+	for i, s := range streams {
+		segs, err := ReadSegments(s)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = &File{Segments: segs, Labels: s.Labels(), Comments: s.Comments()}
+	}
+	t := &Transcript{Sent: files[0], Received: files[1]}
+	for _, b := range blocks {
+		f := t.Stream(b.dir)
+		for _, s := range f.Segments {
+			start, end := s.Offset, s.End()
+			if start < b.start {
+				start = b.start
+			}
+			if end > b.end {
+				end = b.end
+			}
+			if start >= end {
+				continue
+			}
+			m := Message{Dir: b.dir, Offset: start, Data: s.Data[start-s.Offset : end-s.Offset]}
+			if s.Mask != nil {
+				m.Mask = s.Mask[start-s.Offset : end-s.Offset]
+			}
+			t.Messages = append(t.Messages, m)
+		}
+	}
+	return t, nil
+}
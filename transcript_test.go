@@ -0,0 +1,106 @@
+package lhex_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dnesting/lhex"
+)
+
+func TestDecodeTranscript(t *testing.T) {
+	input := `
+# fetching the index
+>  # request
+00000000  "GET / HTTP/1.1\r\n"
+:headers
+00000010  "Host: x\r\n\r\n"
+<
+00000000  "HTTP/1.1 200 OK\r\n"
+:body
+          u16be(end - body) ?? ??
+:end
+>
+          "bye"
+<
+00000100  AA BB
+`
+	tr, err := lhex.DecodeTranscript(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lhex.Message{
+		{Dir: lhex.Sent, Offset: 0, Data: []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")},
+		{Dir: lhex.Received, Offset: 0, Data: []byte("HTTP/1.1 200 OK\r\n\x00\x04\x00\x00"),
+			Mask: append(bytes.Repeat([]byte{0xFF}, 19), 0, 0)},
+		{Dir: lhex.Sent, Offset: 0x1B, Data: []byte("bye")},
+		{Dir: lhex.Received, Offset: 0x100, Data: []byte{0xAA, 0xBB}},
+	}
+	if len(tr.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d: %+v", len(tr.Messages), len(want), tr.Messages)
+	}
+	for i, m := range tr.Messages {
+		w := want[i]
+		if m.Dir != w.Dir || m.Offset != w.Offset || !bytes.Equal(m.Data, w.Data) || !bytes.Equal(m.Mask, w.Mask) {
+			t.Errorf("message %d = %v %X %q %X, want %v %X %q %X", i, m.Dir, m.Offset, m.Data, m.Mask, w.Dir, w.Offset, w.Data, w.Mask)
+		}
+	}
+
+	if segs := tr.Sent.Segments; len(segs) != 1 || string(segs[0].Data) != "GET / HTTP/1.1\r\nHost: x\r\n\r\nbye" {
+		t.Errorf("sent stream = %+v", segs)
+	}
+	if segs := tr.Stream(lhex.Received).Segments; len(segs) != 2 || segs[1].Offset != 0x100 {
+		t.Errorf("received stream = %+v", segs)
+	}
+	if ofs, ok := tr.Sent.Labels.Get("headers"); !ok || ofs != 0x10 {
+		t.Errorf("sent label headers = %X, %v, want 10", ofs, ok)
+	}
+	if ofs, ok := tr.Received.Labels.Get("body"); !ok || ofs != 0x11 {
+		t.Errorf("received label body = %X, %v, want 11", ofs, ok)
+	}
+	if _, ok := tr.Sent.Labels.Get("body"); ok {
+		t.Errorf("received label body found in sent stream")
+	}
+	if got := tr.Sent.Comments.Get(0); len(got) != 2 || got[0] != "fetching the index" || got[1] != "request" {
+		t.Errorf("sent comments at 0 = %q", got)
+	}
+}
+
+func TestDecodeTranscriptErrors(t *testing.T) {
+	for _, input := range []string{
+		"00000000  01 02\n>\n",
+		">\n00000010  01\n<\n00000000  02\n>\n00000000  03\n",
+		"<\n00000000  u8(missing)\n",
+	} {
+		if _, err := lhex.DecodeTranscript(strings.NewReader(input)); err == nil {
+			t.Errorf("DecodeTranscript(%q) succeeded, want error", input)
+		}
+	}
+
+	if _, err := lhex.Decode(strings.NewReader(">\n00000000  01\n")); err == nil {
+		t.Errorf("Decode accepted a direction marker")
+	}
+}
+
+func TestDecodeTranscriptDialect(t *testing.T) {
+	// Each dump in the log starts at 0, so the second one sent must follow the first.
+	input := `client: connecting
+client: >
+client: 00000000  68 69
+server: <
+server: 00000000  6F 6B
+client: >
+client: 00000000  62 79 65
+`
+	dec := lhex.NewDecoder(strings.NewReader(input))
+	dec.SetDialect(&lhex.Dialect{Prefix: regexp.MustCompile(`^\w+: `)})
+	tr, err := dec.ReadTranscript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Messages) != 3 || tr.Messages[2].Dir != lhex.Sent || tr.Messages[2].Offset != 0x10 ||
+		string(tr.Messages[2].Data) != "bye" {
+		t.Errorf("the second dump sent should be moved past the first, got %+v", tr.Messages)
+	}
+}